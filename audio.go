@@ -2,89 +2,398 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	Url "net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/jogramming/dca"
 	Provider "github.com/thirdscam/chatanium-musicbot/provider"
+	"github.com/thirdscam/chatanium-musicbot/util"
 	"github.com/thirdscam/chatanium/src/Util/Log"
 )
 
 var MUSIC_PATH = filepath.Join(os.TempDir(), "chatanium-musicbot")
 
-// DownloadMusic() downloads the music from the given URL and returns the path to the downloaded file.
+// The suffix of the music file being encoded.
+const PARTIAL_SUFFIX = ".part"
+
+// The maximum time to wait for a provider to resolve the media URL.
+const RESOLVE_TIMEOUT = 1 * time.Minute
+
+var errMediaExpired = errors.New("media url is expired")
+
+// DownloadMusic() downloads the music and saves it as the file of its MusicID.
 //
-// key is used to generate the file name, so it must be unique.
-func DownloadMusic(rawURL string, musicId Provider.MusicID) error {
-	// 1. check if the music file already exists.
-	if isExistMusic(musicId) {
-		return nil
+// The media URL is resolved again by the provider right before the download,
+// and if the media URL is rejected (HTTP 403/410), it is resolved and downloaded once more.
+//
+// The concurrent downloads of the same music are coalesced into one (see downloadCall),
+// so the requesters wait for the same encoding and share its result.
+func DownloadMusic(ctx context.Context, music Provider.Music) error {
+	for {
+		downloadsMu.Lock()
+		call, ok := downloads[music.Id]
+		if !ok {
+			// 1. check if the music file already exists.
+			if isExistMusic(music.Id) {
+				downloadsMu.Unlock()
+				musicCache.hit(music.Id)
+				return nil
+			}
+
+			musicCache.miss()
+			call = startDownload(music)
+		}
+
+		// the canceled download is stopping (and removing its file), so wait for it and download again.
+		if call.ctx.Err() != nil {
+			downloadsMu.Unlock()
+			select {
+			case <-call.finished:
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		// the download is stopped only when all of the requesters are canceled
+		call.refs++
+		stop := context.AfterFunc(ctx, call.leave)
+		call.stops = append(call.stops, stop)
+		downloadsMu.Unlock()
+
+		select {
+		case <-call.ready:
+			// the music is being played from now on, so the requester keeps its ref until the encoding is finished.
+			// (the cancel of the requester must not stop the encoding that the player is reading)
+			stop()
+			return call.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
+}
 
-	// 2. Create a directory to store the music files.
-	makeDirectory()
+// isDownloadNeeded reports whether the music must be downloaded. (false if the file exists or is being downloaded)
+func isDownloadNeeded(musicId Provider.MusicID) bool {
+	downloadsMu.Lock()
+	_, ok := downloads[musicId]
+	downloadsMu.Unlock()
 
-	// 3. Check if the URL is valid
-	_, err := Url.ParseRequestURI(rawURL)
-	if err != nil {
-		Log.Error.Printf("[MusicBot] Failed to parse URL: %v", err)
-		return err
+	return !ok && !isExistMusic(musicId)
+}
+
+// downloadCall is a download shared by the requesters of the same music.
+type downloadCall struct {
+	ready    chan struct{} // closed when the music is ready to play (or failed)
+	finished chan struct{} // closed when the encoding is finished
+	err      error
+
+	ctx    context.Context // canceled when all of the requesters are canceled
+	cancel context.CancelFunc
+	refs   int           // the number of the requesters not canceled (guarded by downloadsMu)
+	stops  []func() bool // stop watching the contexts of the requesters
+}
+
+// The downloads in progress (by MusicID)
+var (
+	downloadsMu sync.Mutex
+	downloads   = map[Provider.MusicID]*downloadCall{}
+)
+
+// startDownload starts the download of the music. (downloadsMu must be locked)
+func startDownload(music Provider.Music) *downloadCall {
+	ctx, cancel := context.WithCancel(context.Background())
+	call := &downloadCall{
+		ready:    make(chan struct{}),
+		finished: make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	downloads[music.Id] = call
+
+	go func() {
+		// 2. Create a directory to store the music files.
+		makeDirectory()
+
+		// 3. Download the music file. (retry once if the media URL is expired)
+		enc, err := downloadMusic(ctx, music)
+		if errors.Is(err, errMediaExpired) {
+			Log.Verbose.Printf("[MusicBot] Media URL is expired, resolving again: %s", music.Title)
+			enc, err = downloadMusic(ctx, music)
+		}
+
+		call.err = err
+		close(call.ready)
+
+		// 4. the requesters joined until the end of the encoding share it
+		if err == nil {
+			<-enc.done
+			err = enc.err
+		}
+
+		// 5. the completely encoded file is managed by the cache from now on
+		if err == nil {
+			musicCache.stored(music.Id)
+		}
+
+		downloadsMu.Lock()
+		delete(downloads, music.Id)
+		stops := call.stops
+		downloadsMu.Unlock()
+
+		for _, stop := range stops {
+			stop()
+		}
+		cancel()
+		close(call.finished)
+	}()
+
+	return call
+}
+
+// leave is called when a requester of the download is canceled.
+func (c *downloadCall) leave() {
+	downloadsMu.Lock()
+	defer downloadsMu.Unlock()
+
+	c.refs--
+	if c.refs == 0 {
+		c.cancel()
 	}
+}
+
+// The number of times to retry the failed download, and the time to wait before the first retry. (doubled each time)
+const (
+	DOWNLOAD_RETRIES = 3
+	DOWNLOAD_BACKOFF = 5 * time.Second
+)
+
+var errDownloadFailed = errors.New("download failed")
+
+// DownloadMusicWithRetry() downloads the music by the download scheduler, and retries the failed download with backoff.
+// (the lower priority is started first, see downloadScheduler)
+//
+// if it still fails after DOWNLOAD_RETRIES times, it returns errDownloadFailed with the last error.
+// (the permanent errors are returned without retry, see isPermanentError)
+func DownloadMusicWithRetry(ctx context.Context, music Provider.Music, priority int) error {
+	backoff := DOWNLOAD_BACKOFF
+
+	var err error
+	for attempt := 0; attempt <= DOWNLOAD_RETRIES; attempt++ {
+		if attempt > 0 {
+			Log.Verbose.Printf("[MusicBot] Retrying download in %s (%d/%d): %s", backoff, attempt, DOWNLOAD_RETRIES, music.Title)
+			if !util.SleepContext(ctx, backoff) {
+				return ctx.Err()
+			}
+			backoff *= 2
+		}
+
+		err = scheduler.Download(ctx, music, priority)
+		if err == nil || ctx.Err() != nil || isPermanentError(err) {
+			return err
+		}
+	}
+
+	return fmt.Errorf("%w: %v", errDownloadFailed, err)
+}
+
+// isPermanentError reports whether the download fails in the same way however many times it is retried.
+// (the rate limit, the unavailable server and the untyped errors are retried)
+func isPermanentError(err error) bool {
+	for _, target := range []error{
+		Provider.ErrNotFound,
+		Provider.ErrAgeRestricted,
+		Provider.ErrGeoBlocked,
+		Provider.ErrPrivatePlaylist,
+		Provider.ErrUnsupported,
+		Provider.ErrTooLarge,
+		Provider.ErrNotAllowed,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
 
-	// 4. Create a file to store the music file.
-	file, err := os.Create(getMusicPath(musicId))
+// downloadMusic resolves the media URL of the music and downloads it.
+//
+// It returns when the music is ready to play, with the encoding that is still running. (see encoding)
+func downloadMusic(ctx context.Context, music Provider.Music) (*encoding, error) {
+	// 1. Get a fresh media URL from the provider.
+	rawURL := resolveMusic(ctx, music).RawUrl
+
+	// 2. Check if the URL is valid
+	// only the local library gives the absolute path of the file, and the others must give a http(s) URL.
+	// (otherwise ffmpeg would read the files of the host)
+	if music.Type != "local" || !filepath.IsAbs(rawURL) {
+		u, err := Url.ParseRequestURI(rawURL)
+		if err == nil && u.Scheme != "http" && u.Scheme != "https" {
+			err = fmt.Errorf("%w: not a http(s) URL", Provider.ErrUnsupported)
+		}
+		if err != nil {
+			Log.Error.Printf("[MusicBot] Failed to parse URL: %s", util.RedactUrl(rawURL))
+			return nil, err
+		}
+	}
+
+	// 3. Open the media if the provider reads it by itself. (e.g. to limit the size)
+	var body io.ReadCloser
+	if downloader, ok := providers[music.Type].(Provider.Downloader); ok {
+		var err error
+		body, err = downloader.Open(ctx, rawURL)
+		if err != nil {
+			Log.Error.Printf("[MusicBot] Failed to open media: %v", err)
+			return nil, err
+		}
+	}
+
+	// 4. Create a file to store the music file. (the player waits for the encoder while reading it)
+	// it is encoded to the partial file, and renamed to the music file when the encoding is finished.
+	enc := startEncoding(music.Id)
+	file, err := os.Create(getPartialPath(music.Id))
 	if err != nil {
 		Log.Error.Printf("[MusicBot] Failed to create file: %v", err)
-		return err
+		if body != nil {
+			body.Close()
+		}
+		enc.finish(err)
+		return nil, err
 	}
 
-	// 5. Download the music file.
-	ok, err := download(rawURL, file)
+	// 5. Download the music file. (stopped when ctx is done)
+	ready, err := download(ctx, rawURL, body, file, getMusicPath(music.Id), enc)
 	if err != nil {
 		Log.Error.Printf("[MusicBot] Failed to download file: %v", err)
-		return err
+		if body != nil {
+			body.Close()
+		}
+		file.Close()
+		os.Remove(file.Name())
+		enc.finish(err)
+		return nil, err
 	}
 
-	// 6. waiting for the download stream to be first buffer written
-	<-ok
+	// 6. waiting for the download stream to be first buffer written (the failed file is removed by download)
+	err = <-ready
+	if err != nil {
+		Log.Error.Printf("[MusicBot] Failed to download file: %v", err)
+		return nil, err
+	}
 
-	return nil
+	return enc, nil
+}
+
+// resolveMusic returns the music with a fresh media URL, and fills in its details in the queues. (chapters, etc.)
+// if the provider fails to resolve it, the music at the time of the query is used.
+func resolveMusic(ctx context.Context, music Provider.Music) Provider.Music {
+	provider, ok := providers[music.Type]
+	if !ok {
+		return music
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, RESOLVE_TIMEOUT)
+	defer cancel()
+
+	resolved, err := provider.Resolve(ctx, music)
+	if err != nil {
+		Log.Warn.Printf("[MusicBot] Failed to resolve media URL (using the old one): %v", err)
+		return music
+	}
+
+	UpdateDetails(resolved)
+	return resolved
 }
 
 // Remove music from the local storage.
 //
 // use it when the music file is no longer needed.
 func RemoveMusic(musicId Provider.MusicID) {
+	musicCache.forget(musicId)
+
 	err := os.Remove(getMusicPath(musicId))
 	if err != nil {
 		Log.Error.Printf("[MusicBot] Failed to remove file: %v", err)
 	}
 }
 
+// SeekFunc returns the position to move the playback to, from the current position.
+type SeekFunc func(position time.Duration) time.Duration
+
 // PlayMusic plays the music file to the given voice channel.
 //
 // It returns an error if the music file is not found.
 // so it must be checked before called DownloadMusic().
-func PlayMusic(dgv *discordgo.VoiceConnection, musicId Provider.MusicID, pause chan bool, skip chan bool) {
-	// Open the music file
-	file, err := os.Open(getMusicPath(musicId))
+func PlayMusic(dgv *discordgo.VoiceConnection, musicId Provider.MusicID, pause chan bool, skip chan bool, seek chan SeekFunc) {
+	offset := time.Duration(0)
+
+	for {
+		// Open the music file (it can be still being encoded)
+		file, err := openMusic(musicId)
+		if err != nil {
+			Log.Error.Printf("[MusicBot] Failed to open file: %v", err)
+			return
+		}
+
+		// Create a decoder for the audio file (and skip to the position)
+		decoder := dca.NewDecoder(file)
+		skipFrames(decoder, offset)
+
+		next, isSeeked := playStream(dgv, decoder, pause, skip, seek, offset)
+		file.Close()
+
+		// if the position is moved, play again from the position
+		if !isSeeked {
+			return
+		}
+		offset = next
+	}
+}
+
+// skipFrames reads the frames of the decoder until the position.
+func skipFrames(decoder *dca.Decoder, position time.Duration) {
+	for elapsed := time.Duration(0); elapsed < position; elapsed += decoder.FrameDuration() {
+		if _, err := decoder.OpusFrame(); err != nil {
+			return
+		}
+	}
+}
+
+// StreamMusic plays the live stream to the given voice channel.
+//
+// The stream is encoded on the fly (not saved to the file), so it never ends until skipped or disconnected.
+func StreamMusic(dgv *discordgo.VoiceConnection, stream io.ReadCloser, pause chan bool, skip chan bool) {
+	defer stream.Close()
+
+	// Create an encoder for the stream
+	encodeSession, err := dca.EncodeMem(stream, dca.StdEncodeOptions)
 	if err != nil {
-		Log.Error.Printf("[MusicBot] Failed to open file: %v", err)
+		Log.Error.Printf("[MusicBot] Failed to encode stream: %v", err)
 		return
 	}
-	defer file.Close()
+	defer encodeSession.Cleanup()
 
-	// Create a decoder for the audio file
-	decoder := dca.NewDecoder(file)
+	playStream(dgv, encodeSession, pause, skip, nil, 0) // live stream cannot be seeked
+}
 
+// playStream sends the opus frames of the source to the voice connection, until the source ends or skipped.
+//
+// offset is the position of the source where it starts from.
+// if the seek signal is received, it stops and returns the position to move to. (the caller must play again from there)
+func playStream(dgv *discordgo.VoiceConnection, source dca.OpusReader, pause chan bool, skip chan bool, seek chan SeekFunc, offset time.Duration) (time.Duration, bool) {
 	// Start streaming the audio to the voice connection
 	stop := make(chan error)
-	stream := dca.NewStream(decoder, dgv, stop)
+	stream := dca.NewStream(source, dgv, stop)
 
 	// Variable to keep track of the pause state
 	isPaused := false
@@ -134,10 +443,22 @@ playback:
 			stream.SetPaused(true) // Pause the stream
 			Log.Verbose.Println("[MusicBot] Playback skipped")
 			break playback
+
+		// seek signal to move the playback position (nil channel never receives)
+		case fn := <-seek:
+			stream.SetPaused(true) // Pause the stream
+			position := fn(offset + stream.PlaybackPosition())
+			if position < 0 {
+				position = 0
+			}
+
+			Log.Verbose.Printf("[MusicBot] Playback seeked to %s", position)
+			return position, true
 		}
 	}
 
 	Log.Verbose.Println("[MusicBot] Playback ended.")
+	return 0, false
 }
 
 func reconnect(dgv *discordgo.VoiceConnection) chan bool {
@@ -171,6 +492,27 @@ func getMusicPath(musicId Provider.MusicID) string {
 	return filepath.Join(MUSIC_PATH, string(musicId))
 }
 
+// get the path of the music file being encoded. (renamed to the music file when complete, see completeFile)
+func getPartialPath(musicId Provider.MusicID) string {
+	return getMusicPath(musicId) + PARTIAL_SUFFIX
+}
+
+// completeFile renames the encoded file to the music file if the encoding succeeded, or removes it.
+// (so the music file always has the whole music)
+func completeFile(partialPath, path string, err error) error {
+	if err != nil {
+		os.Remove(partialPath)
+		return err
+	}
+
+	if err := os.Rename(partialPath, path); err != nil {
+		os.Remove(partialPath)
+		return err
+	}
+
+	return nil
+}
+
 // check if the music file exists.
 func isExistMusic(musicId Provider.MusicID) bool {
 	_, err := os.Stat(getMusicPath(musicId))
@@ -182,11 +524,24 @@ func isExistMusic(musicId Provider.MusicID) bool {
 	return true
 }
 
-func download(rawURL string, file *os.File) (chan bool, error) {
-	Log.Verbose.Println(rawURL)
-
-	// 1. Get file path
-	encodeSession, err := dca.EncodeFile(rawURL, dca.StdEncodeOptions)
+// download encodes the media of rawURL into the file, and renames the file to path when the encoding is finished.
+//
+// The returned channel receives nil when the first chunks are written (ready to play),
+// or the error if the encoding is failed before that.
+// if the encoding is stopped by ctx or failed, the partially written file is removed. (never renamed to path)
+// the end of the encoding (and its error) is reported to enc.
+func download(ctx context.Context, rawURL string, body io.ReadCloser, file *os.File, path string, enc *encoding) (chan error, error) {
+	Log.Verbose.Println(util.RedactUrl(rawURL))
+
+	// 1. Get file path (or the media opened by the provider)
+	// the error of reading the body (e.g. ErrTooLarge) is the error of the encode session.
+	var encodeSession *dca.EncodeSession
+	var err error
+	if body != nil {
+		encodeSession, err = dca.EncodeMem(body, dca.StdEncodeOptions)
+	} else {
+		encodeSession, err = dca.EncodeFile(rawURL, dca.StdEncodeOptions)
+	}
 	if err != nil {
 		Log.Error.Printf("[MusicBot] Failed to encode file: %v", err)
 		return nil, err
@@ -197,8 +552,13 @@ func download(rawURL string, file *os.File) (chan bool, error) {
 	writer := bufio.NewWriter(file)
 
 	// 3. Start encode session
-	isWriting := make(chan bool)
+	isWriting := make(chan error, 1)
+	stop := context.AfterFunc(ctx, func() {
+		encodeSession.Stop() // the reader is closed by killing ffmpeg
+	})
 	go func() {
+		defer stop()
+
 		chunkCnt := 0
 		for {
 			buf := make([]byte, 4096)
@@ -206,6 +566,34 @@ func download(rawURL string, file *os.File) (chan bool, error) {
 			if err != nil { // session is closed
 				file.Close()
 				encodeSession.Cleanup()
+				if body != nil {
+					body.Close()
+				}
+
+				result := encodeError(encodeSession)
+				if ctx.Err() != nil {
+					result = ctx.Err()
+				}
+				result = completeFile(file.Name(), path, result)
+				enc.finish(result)
+
+				// if the session is closed before ready, report the result of the encoding.
+				if chunkCnt <= 5 {
+					isWriting <- result
+					return
+				}
+
+				// if the session is stopped after ready, the file is incomplete. (the caller has already returned)
+				if ctx.Err() != nil {
+					Log.Verbose.Printf("[MusicBot] Download canceled, removed the partial file: %s", file.Name())
+					return
+				}
+
+				if result != nil {
+					Log.Error.Printf("[MusicBot] Encoding failed after ready, removed the partial file: %v", result)
+					return
+				}
+
 				if err == io.EOF {
 					return
 				}
@@ -214,21 +602,28 @@ func download(rawURL string, file *os.File) (chan bool, error) {
 			}
 
 			_, err = writer.Write(buf[:n])
-			if err != nil {
-				Log.Verbose.Printf("[MusicBot/Internal] ffmpeg Write Error: %v", err)
-				return
+			if err == nil {
+				err = writer.Flush()
 			}
-
-			err = writer.Flush()
 			if err != nil {
-				Log.Verbose.Printf("[MusicBot/Internal] ffmpeg Flush Error: %v", err)
+				Log.Verbose.Printf("[MusicBot/Internal] ffmpeg Write Error: %v", err)
+				file.Close()
+				encodeSession.Cleanup()
+				if body != nil {
+					body.Close()
+				}
+				err = completeFile(file.Name(), path, err)
+				enc.finish(err)
+				if chunkCnt <= 5 {
+					isWriting <- err
+				}
 				return
 			}
 
 			// if the first write is done, send the signal to the channel
 			if chunkCnt == 5 {
 				Log.Verbose.Printf("[MusicBot/Internal] ready to play.")
-				isWriting <- true
+				isWriting <- nil
 			}
 
 			chunkCnt++
@@ -237,3 +632,25 @@ func download(rawURL string, file *os.File) (chan bool, error) {
 
 	return isWriting, nil
 }
+
+// encodeError returns the error of the finished encode session.
+// if ffmpeg is rejected by the server because the media URL is expired, it returns errMediaExpired.
+func encodeError(encodeSession *dca.EncodeSession) error {
+	err := encodeSession.Error()
+	if err == nil {
+		return nil
+	}
+
+	messages := encodeSession.FFMPEGMessages()
+	if strings.Contains(messages, "403 Forbidden") || strings.Contains(messages, "410 Gone") {
+		return fmt.Errorf("%w: %v", errMediaExpired, err)
+	}
+
+	// the last message of ffmpeg is the reason (the media URL in it can have the credentials)
+	lines := strings.Split(strings.TrimSpace(messages), "\n")
+	if reason := util.RedactText(strings.TrimSpace(lines[len(lines)-1])); reason != "" {
+		return fmt.Errorf("ffmpeg failed: %w (%s)", err, reason)
+	}
+
+	return fmt.Errorf("ffmpeg failed: %w", err)
+}
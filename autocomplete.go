@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	Provider "github.com/thirdscam/chatanium-musicbot/provider"
+	"github.com/thirdscam/chatanium-musicbot/util"
+	"github.com/thirdscam/chatanium/src/Util/Log"
+)
+
+// The time to wait for the user to stop typing before querying the provider.
+const AUTOCOMPLETE_DEBOUNCE = 300 * time.Millisecond
+
+// The time to respond the suggestions from the receipt of the request. (discord waits for the suggestions only 3 seconds)
+const AUTOCOMPLETE_DEADLINE = 2500 * time.Millisecond
+
+// The maximum time to search the suggestions in the background. (e.g. yt-dlp, which rarely finishes within the deadline)
+const AUTOCOMPLETE_SEARCH_TIMEOUT = 10 * time.Second
+
+// The time to keep the suggestions of the same query.
+const AUTOCOMPLETE_CACHE_EXPIRE = 1 * time.Minute
+
+// The maximum number of suggestions. (limited by discord)
+const AUTOCOMPLETE_LIMIT = 25
+
+// The number of candidates to request from the provider.
+const AUTOCOMPLETE_SEARCH_LIMIT = 10
+
+type autocompleteCache struct {
+	music   []Provider.Music
+	expires time.Time
+}
+
+type playlistCache struct {
+	playlists []Provider.Playlist
+	expires   time.Time
+}
+
+var (
+	autocompleteMu sync.Mutex
+
+	// The sequence of the latest request for each user (to drop the outdated requests)
+	autocompleteSeq map[string]uint64 = make(map[string]uint64)
+
+	// The search results for each provider and query
+	autocompleteCaches map[string]autocompleteCache = make(map[string]autocompleteCache)
+
+	// The searches running in the background for each provider and query (closed when the search is finished)
+	autocompleteSearches map[string]chan struct{} = make(map[string]chan struct{})
+
+	// The saved playlists for each provider
+	playlistCaches map[string]playlistCache = make(map[string]playlistCache)
+)
+
+// PlayAutocomplete suggests the queries of /play from the play history of the guild,
+// the saved playlists and the search results of the provider.
+func PlayAutocomplete(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	// all of the lookups share the deadline of the response
+	ctx, cancel := context.WithTimeout(context.Background(), AUTOCOMPLETE_DEADLINE)
+	defer cancel()
+
+	options := util.GetOptions(i)
+
+	query := ""
+	if v, ok := options["query"]; ok && v.Focused {
+		query = strings.TrimSpace(v.StringValue())
+	} else { // only the query option has suggestions
+		return
+	}
+
+	// 1. Suggest from the play history of the guild
+	choices := []*discordgo.ApplicationCommandOptionChoice{}
+	for _, m := range GetHistory(i.GuildID) {
+		if query == "" || strings.Contains(strings.ToLower(m.Title), strings.ToLower(query)) {
+			choices = appendChoice(choices, m)
+		}
+	}
+
+	providerName := GetDefaultProvider(i.GuildID)
+	if v, ok := options["provider"]; ok {
+		providerName = v.StringValue()
+	}
+
+	// 2. Suggest from the saved playlists of the provider (e.g. playlists of the media server)
+	if !util.IsUrl(query) {
+		for _, v := range savedPlaylists(ctx, providerName) {
+			if query == "" || strings.Contains(strings.ToLower(v.Name), strings.ToLower(query)) {
+				choices = appendPlaylistChoice(choices, v)
+			}
+		}
+	}
+
+	// 3. Suggest from the search results of the provider (if the query is not empty or a URL)
+	if query != "" && !util.IsUrl(query) && !isOutdated(ctx, i, query) {
+		for _, m := range searchSuggestions(ctx, providerName, query) {
+			choices = appendChoice(choices, m)
+		}
+	}
+
+	if len(choices) > AUTOCOMPLETE_LIMIT {
+		choices = choices[:AUTOCOMPLETE_LIMIT]
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{
+			Choices: choices,
+		},
+	})
+	if err != nil {
+		Log.Verbose.Printf("[MusicBot] Failed to respond autocomplete: %v", err)
+	}
+}
+
+// isOutdated waits for the user to stop typing,
+// and reports whether a newer request of the same user arrived in the meantime. (or the deadline is exceeded)
+func isOutdated(ctx context.Context, i *discordgo.InteractionCreate, query string) bool {
+	key := i.GuildID + ":" + i.Member.User.ID
+
+	var seq uint64
+	util.WithLock(&autocompleteMu, func() {
+		autocompleteSeq[key]++
+		seq = autocompleteSeq[key]
+	})
+
+	select {
+	case <-time.After(AUTOCOMPLETE_DEBOUNCE):
+	case <-ctx.Done():
+		return true
+	}
+
+	isOutdated := false
+	util.WithLock(&autocompleteMu, func() {
+		isOutdated = autocompleteSeq[key] != seq
+	})
+
+	if isOutdated {
+		Log.Verbose.Printf("[MusicBot] Autocomplete skipped (outdated): %s", query)
+	}
+
+	return isOutdated
+}
+
+// searchSuggestions returns the search results of the provider. (cached for a while)
+//
+// The search is kept running in the background when the deadline of ctx is exceeded,
+// so the results of the slow provider are suggested from the cache on the next request.
+func searchSuggestions(ctx context.Context, providerName, query string) []Provider.Music {
+	provider, ok := providers[providerName]
+	if !ok {
+		return nil
+	}
+
+	key := providerName + ":" + strings.ToLower(query)
+
+	var cache autocompleteCache
+	var isCached bool
+	var done chan struct{}
+	util.WithLock(&autocompleteMu, func() {
+		cache, isCached = autocompleteCaches[key]
+		if isCached && time.Now().Before(cache.expires) {
+			return
+		}
+
+		// share the search of the same query
+		done = autocompleteSearches[key]
+		if done == nil {
+			done = make(chan struct{})
+			autocompleteSearches[key] = done
+			go searchInBackground(provider, key, query, done)
+		}
+	})
+
+	if done == nil {
+		return cache.music
+	}
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		Log.Verbose.Printf("[MusicBot] Autocomplete search is not finished in time (cached for the next request): %s", query)
+		return nil
+	}
+
+	util.WithLock(&autocompleteMu, func() {
+		cache = autocompleteCaches[key]
+	})
+
+	return cache.music
+}
+
+// searchInBackground searches the query, and caches the results. (done is closed when the search is finished)
+func searchInBackground(provider Provider.Interface, key, query string, done chan struct{}) {
+	defer func() {
+		util.WithLock(&autocompleteMu, func() {
+			delete(autocompleteSearches, key)
+		})
+		close(done)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), AUTOCOMPLETE_SEARCH_TIMEOUT)
+	defer cancel()
+
+	m, err := provider.Search(ctx, query, AUTOCOMPLETE_SEARCH_LIMIT)
+	if err != nil {
+		Log.Verbose.Printf("[MusicBot] Failed to search suggestions: %v", err)
+		return
+	}
+
+	util.WithLock(&autocompleteMu, func() {
+		// drop the expired caches (to keep the cache small)
+		for k, v := range autocompleteCaches {
+			if time.Now().After(v.expires) {
+				delete(autocompleteCaches, k)
+			}
+		}
+
+		autocompleteCaches[key] = autocompleteCache{
+			music:   m,
+			expires: time.Now().Add(AUTOCOMPLETE_CACHE_EXPIRE),
+		}
+	})
+}
+
+// savedPlaylists returns the saved playlists of the provider. (cached for a while)
+//
+// It returns nil if the provider does not keep the playlists.
+func savedPlaylists(ctx context.Context, providerName string) []Provider.Playlist {
+	lister, ok := providers[providerName].(Provider.PlaylistLister)
+	if !ok {
+		return nil
+	}
+
+	var cache playlistCache
+	var isCached bool
+	util.WithLock(&autocompleteMu, func() {
+		cache, isCached = playlistCaches[providerName]
+	})
+
+	if isCached && time.Now().Before(cache.expires) {
+		return cache.playlists
+	}
+
+	playlists, err := lister.Playlists(ctx)
+	if err != nil {
+		Log.Verbose.Printf("[MusicBot] Failed to get saved playlists: %v", err)
+		return nil
+	}
+
+	util.WithLock(&autocompleteMu, func() {
+		playlistCaches[providerName] = playlistCache{
+			playlists: playlists,
+			expires:   time.Now().Add(AUTOCOMPLETE_CACHE_EXPIRE),
+		}
+	})
+
+	return playlists
+}
+
+// appendPlaylistChoice appends the saved playlist as a choice. (the value is the query to enqueue it)
+func appendPlaylistChoice(choices []*discordgo.ApplicationCommandOptionChoice, playlist Provider.Playlist) []*discordgo.ApplicationCommandOptionChoice {
+	// the truncated query cannot find the playlist (the value is limited to 100 characters)
+	value := playlist.Query
+	if len([]rune(value)) > 100 {
+		return choices
+	}
+
+	for _, v := range choices {
+		if v.Value == value {
+			return choices
+		}
+	}
+
+	return append(choices, &discordgo.ApplicationCommandOptionChoice{
+		Name:  util.Truncate(fmt.Sprintf("Playlist: %s (%d songs)", playlist.Name, playlist.SongCount), 100),
+		Value: value,
+	})
+}
+
+// appendChoice appends the music as a choice. (the value is the query to play it)
+func appendChoice(choices []*discordgo.ApplicationCommandOptionChoice, m Provider.Music) []*discordgo.ApplicationCommandOptionChoice {
+	// the value of the choice is limited to 100 characters, so use the title if the URL is too long.
+	value := m.WebpageUrl
+	if value == "" || len([]rune(value)) > 100 {
+		value = util.Truncate(m.Title, 100)
+	}
+
+	// skip the duplicated choices (e.g. history and search results)
+	for _, v := range choices {
+		if v.Value == value {
+			return choices
+		}
+	}
+
+	return append(choices, &discordgo.ApplicationCommandOptionChoice{
+		Name:  util.Truncate(m.Title, 100),
+		Value: value,
+	})
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	Provider "github.com/thirdscam/chatanium-musicbot/provider"
+	"github.com/thirdscam/chatanium-musicbot/util"
+	"github.com/thirdscam/chatanium/src/Util/Log"
+)
+
+// The maximum total size of the music files that are not in any queue. (MUSICBOT_CACHE_SIZE overrides it, in bytes)
+var CACHE_SIZE int64 = 1024 * 1024 * 1024
+
+// The maximum time to keep the music file that is not in any queue. (MUSICBOT_CACHE_AGE overrides it, e.g. "24h")
+var CACHE_AGE = 24 * time.Hour
+
+// The interval to evict the expired music files.
+const CACHE_TRIM_INTERVAL = 10 * time.Minute
+
+// The name of the music file. ("<PROVIDER>:<sha256>", the MusicID of the providers)
+// the other files in MUSIC_PATH are never indexed nor removed. (it may be a shared directory)
+var musicFileName = regexp.MustCompile(`^[A-Z]+:[0-9a-f]{64}$`)
+
+func init() {
+	if v := os.Getenv("MUSICBOT_CACHE_PATH"); v != "" {
+		MUSIC_PATH = v
+	}
+	if v, err := strconv.ParseInt(os.Getenv("MUSICBOT_CACHE_SIZE"), 10, 64); err == nil && v >= 0 {
+		CACHE_SIZE = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("MUSICBOT_CACHE_AGE")); err == nil && v >= 0 {
+		CACHE_AGE = v
+	}
+}
+
+// cacheFile is a music file in MUSIC_PATH.
+type cacheFile struct {
+	size     int64
+	lastUsed time.Time
+}
+
+// CacheStats is the statistics of the music cache.
+type CacheStats struct {
+	Files      int
+	Size       int64
+	Referenced int // the number of the files in the queues (never evicted)
+	Hits       uint64
+	Misses     uint64
+	Evictions  uint64
+}
+
+// audioCache manages the music files of MUSIC_PATH.
+//
+// The music in the queues of all channels is reference-counted, and its file is never evicted.
+// the other files are kept for the next request, and evicted in least recently used order
+// when they exceed CACHE_SIZE in total or are not used for CACHE_AGE.
+type audioCache struct {
+	mu    sync.Mutex
+	refs  map[Provider.MusicID]int // the number of the music in the queues
+	files map[Provider.MusicID]*cacheFile
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+var musicCache = &audioCache{
+	refs:  map[Provider.MusicID]int{},
+	files: map[Provider.MusicID]*cacheFile{},
+}
+
+// Start indexes the music files left in MUSIC_PATH, and evicts the expired files periodically.
+//
+// Only the completely encoded music files are indexed, and the partial files left by a crash are removed.
+// (the encoder renames the partial file to the music file when it is complete, see completeFile)
+func (c *audioCache) Start() {
+	entries, err := os.ReadDir(MUSIC_PATH)
+	if err != nil && !os.IsNotExist(err) {
+		Log.Warn.Printf("[MusicBot] Failed to read the music cache: %v", err)
+	}
+
+	c.mu.Lock()
+	for _, v := range entries {
+		info, err := v.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		name := strings.TrimSuffix(v.Name(), PARTIAL_SUFFIX)
+		if !musicFileName.MatchString(name) {
+			continue
+		}
+
+		if name != v.Name() { // the partial file
+			if err := os.Remove(filepath.Join(MUSIC_PATH, v.Name())); err != nil {
+				Log.Warn.Printf("[MusicBot] Failed to remove the partial file: %v", err)
+			}
+			continue
+		}
+
+		c.files[Provider.MusicID(v.Name())] = &cacheFile{size: info.Size(), lastUsed: info.ModTime()}
+	}
+	Log.Verbose.Printf("[MusicBot] Music cache loaded: %d files (%s)", len(c.files), MUSIC_PATH)
+	c.trim()
+	c.mu.Unlock()
+
+	go func() {
+		for range time.Tick(CACHE_TRIM_INTERVAL) {
+			c.mu.Lock()
+			c.trim()
+			c.mu.Unlock()
+		}
+	}()
+}
+
+// acquire counts the music added to a queue.
+func (c *audioCache) acquire(music ...Provider.Music) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, v := range music {
+		c.refs[v.Id]++
+	}
+}
+
+// release counts the music removed from a queue. (the file is kept until evicted)
+func (c *audioCache) release(music ...Provider.Music) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for _, v := range music {
+		if c.refs[v.Id]--; c.refs[v.Id] <= 0 {
+			delete(c.refs, v.Id)
+		}
+
+		if f, ok := c.files[v.Id]; ok {
+			f.lastUsed = now
+		}
+	}
+
+	c.trim()
+}
+
+// hit counts the request of the music whose file exists.
+func (c *audioCache) hit(musicId Provider.MusicID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.hits++
+	if f, ok := c.files[musicId]; ok {
+		f.lastUsed = time.Now()
+	}
+}
+
+// miss counts the request of the music that must be downloaded.
+func (c *audioCache) miss() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.misses++
+}
+
+// stored adds the file of the music whose encoding is finished.
+func (c *audioCache) stored(musicId Provider.MusicID) {
+	info, err := os.Stat(getMusicPath(musicId))
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.files[musicId] = &cacheFile{size: info.Size(), lastUsed: time.Now()}
+	c.trim()
+}
+
+// forget removes the music from the cache. (the file is removed by the caller)
+func (c *audioCache) forget(musicId Provider.MusicID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.files, musicId)
+}
+
+// trim evicts the files that are not in any queue, until they fit in the budget. (c.mu must be locked)
+func (c *audioCache) trim() {
+	type candidate struct {
+		id Provider.MusicID
+		*cacheFile
+	}
+
+	candidates := []candidate{}
+	size := int64(0)
+	for id, f := range c.files {
+		if c.refs[id] > 0 {
+			continue
+		}
+
+		candidates = append(candidates, candidate{id, f})
+		size += f.size
+	}
+
+	// the least recently used file is evicted first
+	sort.Slice(candidates, func(a, b int) bool {
+		return candidates[a].lastUsed.Before(candidates[b].lastUsed)
+	})
+
+	now := time.Now()
+	for _, v := range candidates {
+		if size <= CACHE_SIZE && now.Sub(v.lastUsed) <= CACHE_AGE {
+			break
+		}
+
+		Log.Verbose.Printf("[MusicBot] Evicting music from the cache: %s", v.id)
+		if err := os.Remove(getMusicPath(v.id)); err != nil && !os.IsNotExist(err) {
+			Log.Warn.Printf("[MusicBot] Failed to evict music: %v", err)
+			continue
+		}
+
+		delete(c.files, v.id)
+		size -= v.size
+		c.evictions++
+	}
+}
+
+// Stats returns the statistics of the cache.
+func (c *audioCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := CacheStats{Files: len(c.files), Hits: c.hits, Misses: c.misses, Evictions: c.evictions}
+	for id, f := range c.files {
+		stats.Size += f.size
+		if c.refs[id] > 0 {
+			stats.Referenced++
+		}
+	}
+
+	return stats
+}
+
+// Cache shows the statistics of the music cache.
+func Cache(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	stats := musicCache.Stats()
+
+	hitRate := 0.0
+	if total := stats.Hits + stats.Misses; total > 0 {
+		hitRate = float64(stats.Hits) / float64(total) * 100
+	}
+
+	util.EphemeralResponse(s, i, fmt.Sprintf(
+		"**Music cache**\nFiles: %d (%d in queues)\nSize: %.1f MB / %.1f MB\nHits: %d, Misses: %d (hit rate: %.1f%%)\nEvictions: %d",
+		stats.Files, stats.Referenced,
+		float64(stats.Size)/1024/1024, float64(CACHE_SIZE)/1024/1024,
+		stats.Hits, stats.Misses, hitRate,
+		stats.Evictions,
+	))
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	Provider "github.com/thirdscam/chatanium-musicbot/provider"
+)
+
+// useMusicPath sets MUSIC_PATH and the budget of the cache for the test.
+func useMusicPath(t *testing.T, size int64, age time.Duration) string {
+	dir := t.TempDir()
+
+	oldPath, oldSize, oldAge := MUSIC_PATH, CACHE_SIZE, CACHE_AGE
+	MUSIC_PATH, CACHE_SIZE, CACHE_AGE = dir, size, age
+	t.Cleanup(func() {
+		MUSIC_PATH, CACHE_SIZE, CACHE_AGE = oldPath, oldSize, oldAge
+	})
+
+	return dir
+}
+
+// testMusicId returns the MusicID of the name. (named as the providers do)
+func testMusicId(name string) Provider.MusicID {
+	return Provider.MusicID("TEST:" + strings.Repeat(name, 64)[:64])
+}
+
+func TestAudioCacheTrim(t *testing.T) {
+	type file struct {
+		name string
+		size int64
+		age  time.Duration // since the last use
+		refs int           // the number of the music in the queues
+	}
+
+	tests := []struct {
+		name    string
+		size    int64
+		age     time.Duration
+		files   []file
+		evicted string // the names of the evicted files
+	}{
+		{
+			name:    "within the budget",
+			size:    300,
+			age:     time.Hour,
+			files:   []file{{"a", 100, time.Minute, 0}, {"b", 100, 2 * time.Minute, 0}},
+			evicted: "",
+		},
+		{
+			name:    "least recently used first",
+			size:    150,
+			age:     time.Hour,
+			files:   []file{{"a", 100, time.Minute, 0}, {"b", 100, 2 * time.Minute, 0}},
+			evicted: "b",
+		},
+		{
+			name:    "expired",
+			size:    1000,
+			age:     time.Hour,
+			files:   []file{{"a", 100, time.Minute, 0}, {"b", 100, 2 * time.Hour, 0}},
+			evicted: "b",
+		},
+		{
+			name:    "in the queue",
+			size:    0,
+			age:     time.Hour,
+			files:   []file{{"a", 100, 2 * time.Hour, 1}, {"b", 100, time.Minute, 0}},
+			evicted: "b",
+		},
+		{
+			name:    "referenced files are not counted",
+			size:    100,
+			age:     time.Hour,
+			files:   []file{{"a", 500, time.Minute, 2}, {"b", 100, time.Minute, 0}},
+			evicted: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := useMusicPath(t, tt.size, tt.age)
+			c := &audioCache{refs: map[Provider.MusicID]int{}, files: map[Provider.MusicID]*cacheFile{}}
+
+			for _, v := range tt.files {
+				id := testMusicId(v.name)
+				os.WriteFile(filepath.Join(dir, string(id)), make([]byte, v.size), 0o644)
+				c.files[id] = &cacheFile{size: v.size, lastUsed: time.Now().Add(-v.age)}
+				if v.refs > 0 {
+					c.refs[id] = v.refs
+				}
+			}
+
+			c.mu.Lock()
+			c.trim()
+			c.mu.Unlock()
+
+			evicted := ""
+			for _, v := range tt.files {
+				id := testMusicId(v.name)
+				_, isIndexed := c.files[id]
+				_, err := os.Stat(filepath.Join(dir, string(id)))
+				if isIndexed != (err == nil) {
+					t.Errorf("%s: indexed %v, but the file exists %v", v.name, isIndexed, err == nil)
+				}
+				if !isIndexed {
+					evicted += v.name
+				}
+			}
+
+			if evicted != tt.evicted {
+				t.Errorf("evicted %q, want %q", evicted, tt.evicted)
+			}
+		})
+	}
+}
+
+func TestAudioCacheRefs(t *testing.T) {
+	useMusicPath(t, 0, time.Hour) // every file out of the queues is evicted at once
+	c := &audioCache{refs: map[Provider.MusicID]int{}, files: map[Provider.MusicID]*cacheFile{}}
+
+	music := Provider.Music{Id: testMusicId("a")}
+	os.WriteFile(getMusicPath(music.Id), []byte("opus"), 0o644)
+
+	// the music is in two queues
+	c.acquire(music, music)
+	c.stored(music.Id)
+
+	tests := []struct {
+		action   func()
+		refs     int
+		isStored bool
+	}{
+		{func() {}, 2, true},
+		{func() { c.release(music) }, 1, true},
+		{func() { c.release(music) }, 0, false}, // evicted when the last queue releases it
+	}
+
+	for j, tt := range tests {
+		tt.action()
+		if c.refs[music.Id] != tt.refs {
+			t.Errorf("#%d: refs %d, want %d", j, c.refs[music.Id], tt.refs)
+		}
+		if _, ok := c.files[music.Id]; ok != tt.isStored {
+			t.Errorf("#%d: stored %v, want %v", j, ok, tt.isStored)
+		}
+	}
+
+	if _, ok := c.refs[music.Id]; ok {
+		t.Error("the released music is left in the refs")
+	}
+}
+
+func TestAudioCacheStart(t *testing.T) {
+	dir := useMusicPath(t, 1<<30, time.Hour)
+
+	complete := string(testMusicId("a"))
+	partial := string(testMusicId("b")) + PARTIAL_SUFFIX
+	others := []string{"notes.txt", "backup.part", "TEST:short"} // not the files of the bot
+	for _, v := range append([]string{complete, partial}, others...) {
+		os.WriteFile(filepath.Join(dir, v), []byte("data"), 0o644)
+	}
+
+	c := &audioCache{refs: map[Provider.MusicID]int{}, files: map[Provider.MusicID]*cacheFile{}}
+	c.Start()
+
+	if len(c.files) != 1 || c.files[Provider.MusicID(complete)] == nil {
+		t.Fatalf("unexpected index: %v", c.files)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, partial)); !os.IsNotExist(err) {
+		t.Errorf("the partial file is left: %v", err)
+	}
+
+	for _, v := range others {
+		if _, err := os.Stat(filepath.Join(dir, v)); err != nil {
+			t.Errorf("the other file is removed: %s", v)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// The handlers of the message components (select menus, buttons, etc.)
+//
+// The custom ID of a component is formed as "<prefix>:<payload>",
+// and the handler is chosen by the prefix. (payload is passed to the handler)
+var componentHandlers = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate, payload string){
+	"musicbot.search":   onSearchSelect,
+	"musicbot.playlist": onPlaylistConfirm,
+	"musicbot.import":   onImportCancel,
+}
+
+var bindComponentsOnce sync.Once
+
+// bindComponents registers the handler of the message components to the session.
+//
+// The runtime only routes the slash commands to the module,
+// so the commands that send components must call it before sending them.
+func bindComponents(s *discordgo.Session) {
+	bindComponentsOnce.Do(func() {
+		s.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			if i.Type != discordgo.InteractionMessageComponent {
+				return
+			}
+
+			prefix, payload, _ := strings.Cut(i.MessageComponentData().CustomID, ":")
+			handler, ok := componentHandlers[prefix]
+			if !ok { // not a component of this module
+				return
+			}
+
+			handler(s, i, payload)
+		})
+	})
+}
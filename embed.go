@@ -1,6 +1,10 @@
 package main
 
 import (
+	"bytes"
+	"net/http"
+	"sync"
+
 	"github.com/bwmarrin/discordgo"
 	"github.com/thirdscam/chatanium/src/Util/Log"
 )
@@ -9,32 +13,74 @@ type EmbedState struct {
 	messageID    string
 	Title        string
 	ThumbnailUrl string
+	Artwork      []byte // cover art image (used when there is no ThumbnailUrl)
 }
 
-var metadatas = map[string]EmbedState{}
+// The status embed of each channel (the live stream updates it from another goroutine)
+var (
+	metadatasMu sync.Mutex
+	metadatas   = map[string]EmbedState{}
+)
 
-// SendStatusEmbed sends the status embed.
-// if the embed is already created, must call SetStatusEmbed.
-func SendStatusEmbed(s *discordgo.Session, channelID string, form EmbedState) string {
+// statusEmbed builds the status embed.
+// if the form has an artwork, it is attached to the message as a file.
+func statusEmbed(form EmbedState) (*discordgo.MessageEmbed, []*discordgo.File) {
 	embed := &discordgo.MessageEmbed{
 		Title:       "Now Playing",
 		Description: form.Title,
-		Thumbnail: &discordgo.MessageEmbedThumbnail{
+		Color:       0x9f7fed,
+	}
+
+	if form.ThumbnailUrl != "" {
+		embed.Thumbnail = &discordgo.MessageEmbedThumbnail{
 			URL: form.ThumbnailUrl,
+		}
+		return embed, nil
+	}
+
+	if len(form.Artwork) == 0 {
+		return embed, nil
+	}
+
+	contentType := http.DetectContentType(form.Artwork)
+	name := "artwork.jpg"
+	if contentType == "image/png" {
+		name = "artwork.png"
+	}
+
+	embed.Thumbnail = &discordgo.MessageEmbedThumbnail{
+		URL: "attachment://" + name,
+	}
+
+	return embed, []*discordgo.File{
+		{
+			Name:        name,
+			ContentType: contentType,
+			Reader:      bytes.NewReader(form.Artwork),
 		},
-		Color: 0x9f7fed,
 	}
+}
+
+// SendStatusEmbed sends the status embed.
+// if the embed is already created, must call SetStatusEmbed.
+func SendStatusEmbed(s *discordgo.Session, channelID string, form EmbedState) string {
+	embed, files := statusEmbed(form)
 
-	m, err := s.ChannelMessageSendEmbed(channelID, embed)
+	m, err := s.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+		Embeds: []*discordgo.MessageEmbed{embed},
+		Files:  files,
+	})
 	if err != nil {
 		return ""
 	}
 
-	metadatas[m.ID] = EmbedState{
+	metadatasMu.Lock()
+	metadatas[channelID] = EmbedState{
 		messageID:    m.ID,
 		Title:        form.Title,
 		ThumbnailUrl: form.ThumbnailUrl,
 	}
+	metadatasMu.Unlock()
 
 	return m.ID
 }
@@ -42,34 +88,39 @@ func SendStatusEmbed(s *discordgo.Session, channelID string, form EmbedState) st
 // SetStatusEmbed sets the status embed.
 // if the embed is not found, it will create a new one.
 func SetStatusEmbed(s *discordgo.Session, channelID string, form EmbedState) string {
-	embed := &discordgo.MessageEmbed{
-		Title:       "Now Playing",
-		Description: form.Title,
-		Color:       0x9f7fed,
-	}
+	embed, files := statusEmbed(form)
+	embeds := []*discordgo.MessageEmbed{embed}
 
-	m, err := s.ChannelMessageEditEmbed(channelID, metadatas[channelID].messageID, embed)
+	metadatasMu.Lock()
+	messageID := metadatas[channelID].messageID
+	metadatasMu.Unlock()
+
+	m, err := s.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		ID:          messageID,
+		Channel:     channelID,
+		Embeds:      &embeds,
+		Files:       files,
+		Attachments: &[]*discordgo.MessageAttachment{}, // remove the artwork of the previous music
+	})
 	if err != nil {
-		newMessageID := SendStatusEmbed(s, channelID, form)
-		metadatas[channelID] = EmbedState{
-			messageID:    newMessageID,
-			Title:        form.Title,
-			ThumbnailUrl: form.ThumbnailUrl,
-		}
-		return newMessageID
+		return SendStatusEmbed(s, channelID, form)
 	}
 
-	metadatas[m.ID] = EmbedState{
+	metadatasMu.Lock()
+	metadatas[channelID] = EmbedState{
 		messageID:    m.ID,
 		Title:        form.Title,
 		ThumbnailUrl: form.ThumbnailUrl,
 	}
+	metadatasMu.Unlock()
 
 	return m.ID
 }
 
 func RemoveStatusEmbed(s *discordgo.Session, channelID string) {
+	metadatasMu.Lock()
 	messageID := metadatas[channelID].messageID
+	metadatasMu.Unlock()
 
 	err := s.ChannelMessageDelete(channelID, messageID)
 	if err != nil {
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	Provider "github.com/thirdscam/chatanium-musicbot/provider"
+	"github.com/thirdscam/chatanium-musicbot/util"
+	"github.com/thirdscam/chatanium/src/Util/Log"
+)
+
+// The maximum size of the file to import.
+const IMPORT_MAX_SIZE = 1024 * 1024
+
+// The maximum number of lines to import at once.
+const IMPORT_MAX_LINES = 100
+
+// The number of lines to query at the same time.
+const IMPORT_CONCURRENCY = 4
+
+// The maximum length of the message of Discord.
+const MESSAGE_MAX_LENGTH = 2000
+
+// importLine is the result of a line (query) of the import.
+type importLine struct {
+	Query   string
+	Music   []Provider.Music
+	Skipped []Provider.SkippedEntry
+	Err     error
+}
+
+// Import enqueues the songs listed in the uploaded file. (.txt, .m3u or .json)
+func Import(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	bindComponents(s) // the cancel button of the import
+
+	options := util.GetOptions(i)
+	attachment := i.ApplicationCommandData().Resolved.Attachments[options["file"].Value.(string)]
+	if attachment == nil {
+		util.EphemeralResponse(s, i, "**Cannot find the uploaded file!**\nPlease upload the file again.")
+		return
+	}
+
+	Log.Verbose.Printf("[MusicBot] Import command called by %s (C:%s, %s)", i.Member.User.Username, i.ChannelID, attachment.Filename)
+
+	if attachment.Size > IMPORT_MAX_SIZE {
+		util.EphemeralResponse(s, i, "**This file is too large.**\nPlease upload a smaller file. (up to 1MB)")
+		return
+	}
+
+	util.EphemeralResponse(s, i, "**Importing songs...**\nIt might take a while to search all of the songs.\n(The first song will automatically play when it's ready.)")
+
+	channelID := getChannelIdByUser(s, i.GuildID, i.Member.User.ID)
+	if channelID == "" {
+		util.EditResponse(s, i, "**Failed to join voice channel.**\nPlease rejoin the voice channel and try again. (or you're not in a voice channel)")
+		return
+	}
+
+	// Read the queries of the file
+	body, err := downloadAttachment(attachment)
+	if err != nil {
+		Log.Verbose.Printf("[MusicBot] Failed to download the file to import: %s", err)
+		util.EditResponse(s, i, "**Failed to read the uploaded file.**\nPlease try again.")
+		return
+	}
+
+	queries, err := parseImport(attachment.Filename, body)
+	if err != nil {
+		Log.Verbose.Printf("[MusicBot] Failed to parse the file to import: %s", err)
+		util.EditResponse(s, i, fmt.Sprintf("**Failed to read the uploaded file.**\nPlease upload a .txt, .m3u or .json file. (%s)", err))
+		return
+	}
+
+	importQueries(s, i, channelID, queries, "")
+}
+
+// importQueries queries the songs of the lines concurrently, and enqueues all of them in the order of the lines.
+//
+// The result of each line is reported above the progress of the queue.
+// (providerName is the provider of all lines, or empty to choose it by each line)
+func importQueries(s *discordgo.Session, i *discordgo.InteractionCreate, channelID ChannelID, queries []string, providerName string) {
+	if len(queries) == 0 {
+		util.EditResponse(s, i, "**There is no song to import.**")
+		return
+	}
+
+	if len(queries) > IMPORT_MAX_LINES {
+		util.EditResponse(s, i, fmt.Sprintf("**Too many songs!**\nPlease import up to %d songs at once.", IMPORT_MAX_LINES))
+		return
+	}
+
+	util.EditResponse(s, i, fmt.Sprintf("**Searching %d songs...**", len(queries)))
+	lines := resolveQueries(i.GuildID, queries, providerName)
+
+	m := []Provider.Music{}
+	skipped := []Provider.SkippedEntry{}
+	for _, v := range lines {
+		m = append(m, v.Music...)
+		skipped = append(skipped, v.Skipped...)
+	}
+
+	report := importReport(lines)
+	if len(m) == 0 {
+		util.EditResponse(s, i, util.Truncate(report+"\n**Cannot find any music!**", MESSAGE_MAX_LENGTH))
+		return
+	}
+
+	enqueueMusicWithHeader(s, i, channelID, m, skipped, report+"\n")
+}
+
+// resolveQueries queries the lines concurrently. (up to IMPORT_CONCURRENCY at once)
+//
+// Each line is handled by the given provider, or the provider which claims it as its URL,
+// or the default search provider of the guild.
+func resolveQueries(guildID string, queries []string, providerName string) []importLine {
+	result := make([]importLine, len(queries))
+
+	wg := sync.WaitGroup{}
+	sem := make(chan struct{}, IMPORT_CONCURRENCY)
+	for j, query := range queries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			result[j].Query = query
+
+			provider, ok := selectProvider(guildID, providerName, query)
+			if !ok {
+				result[j].Err = fmt.Errorf("%w: provider not found", Provider.ErrUnsupported)
+				return
+			}
+
+			result[j].Music, result[j].Skipped, result[j].Err = queryMusic(provider, query)
+		}()
+	}
+	wg.Wait()
+
+	return result
+}
+
+// The maximum length of the report of the lines. (the rest of the message is for the progress of the queue)
+const IMPORT_REPORT_LENGTH = 1200
+
+// importReport builds the result of each line. (success or the reason of failure)
+func importReport(lines []importLine) string {
+	succeeded := 0
+	report := ""
+	omitted := 0
+	for _, v := range lines {
+		query := util.Truncate(v.Query, 60)
+
+		var line string
+		switch {
+		case v.Err != nil:
+			line = fmt.Sprintf("\n❌ `%s` - %s", query, shortErrorMessage(v.Err))
+		case len(v.Music) == 1:
+			succeeded++
+			line = fmt.Sprintf("\n✅ `%s` - %s", query, util.Truncate(v.Music[0].Title, 60))
+		default:
+			succeeded++
+			line = fmt.Sprintf("\n✅ `%s` - %d songs", query, len(v.Music))
+		}
+
+		if len(report)+len(line) > IMPORT_REPORT_LENGTH {
+			omitted++
+			continue
+		}
+		report += line
+	}
+
+	if omitted > 0 {
+		report += fmt.Sprintf("\n(+%d more lines)", omitted)
+	}
+
+	return fmt.Sprintf("**Imported %d of %d lines:**", succeeded, len(lines)) + report + "\n"
+}
+
+// shortErrorMessage returns the first line of the message of queryErrorMessage. (without the bold)
+func shortErrorMessage(err error) string {
+	message, _, _ := strings.Cut(queryErrorMessage(err), "\n")
+	return strings.Trim(message, "*")
+}
+
+// splitQueries splits the query into multiple queries. (by new lines or semicolons)
+func splitQueries(query string) []string {
+	result := []string{}
+	for _, v := range strings.FieldsFunc(query, func(r rune) bool { return r == '\n' || r == ';' }) {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+
+	return result
+}
+
+// downloadAttachment reads the uploaded file. (up to IMPORT_MAX_SIZE)
+func downloadAttachment(attachment *discordgo.MessageAttachment) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, attachment.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, IMPORT_MAX_SIZE))
+}
+
+// parseImport returns the queries of the file to import. (by the extension of the file name)
+//
+//   - .txt: a URL or search term per line ("#" comments are ignored)
+//   - .m3u, .m3u8: the URLs of the playlist (the local files are searched by their title)
+//   - .json: an array of URLs and search terms, or objects with "url", "query" or "artist" and "title"
+func parseImport(filename string, body []byte) ([]string, error) {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".txt":
+		return parseImportText(string(body)), nil
+	case ".m3u", ".m3u8":
+		return parseImportM3u(string(body)), nil
+	case ".json":
+		return parseImportJson(body)
+	}
+
+	return nil, fmt.Errorf("unsupported file type: %s", filename)
+}
+
+func parseImportText(body string) []string {
+	result := []string{}
+	for _, v := range strings.Split(body, "\n") {
+		v = strings.TrimSpace(v)
+		if v == "" || strings.HasPrefix(v, "#") {
+			continue
+		}
+		result = append(result, v)
+	}
+
+	return result
+}
+
+func parseImportM3u(body string) []string {
+	result := []string{}
+	title := "" // the title of the next entry (#EXTINF:<duration>,<title>)
+	for _, v := range strings.Split(body, "\n") {
+		v = strings.TrimSpace(v)
+		switch {
+		case v == "":
+			continue
+		case strings.HasPrefix(v, "#EXTINF:"):
+			_, title, _ = strings.Cut(v, ",")
+			continue
+		case strings.HasPrefix(v, "#"):
+			continue
+		}
+
+		// the local files of the player cannot be played, so they are searched by the title
+		if !util.IsUrl(v) {
+			if title == "" {
+				title = strings.TrimSuffix(path.Base(strings.ReplaceAll(v, "\\", "/")), path.Ext(v))
+			}
+			v = title
+		}
+
+		result = append(result, strings.TrimSpace(v))
+		title = ""
+	}
+
+	return result
+}
+
+func parseImportJson(body []byte) ([]string, error) {
+	var data []any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, fmt.Errorf("not a JSON array: %v", err)
+	}
+
+	result := []string{}
+	for _, v := range data {
+		switch v := v.(type) {
+		case string:
+			if v = strings.TrimSpace(v); v != "" {
+				result = append(result, v)
+			}
+		case map[string]any:
+			str := func(key string) string {
+				s, _ := v[key].(string)
+				return strings.TrimSpace(s)
+			}
+
+			switch {
+			case str("url") != "":
+				result = append(result, str("url"))
+			case str("query") != "":
+				result = append(result, str("query"))
+			case str("title") != "" && str("artist") != "":
+				result = append(result, str("artist")+" - "+str("title"))
+			case str("title") != "":
+				result = append(result, str("title"))
+			}
+		}
+	}
+
+	return result, nil
+}
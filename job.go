@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	Provider "github.com/thirdscam/chatanium-musicbot/provider"
+	"github.com/thirdscam/chatanium-musicbot/util"
+	"github.com/thirdscam/chatanium/src/Util/Log"
+)
+
+// ImportJob is the music being downloaded for the queue by a command. (tracked to be canceled)
+type ImportJob struct {
+	Id        string
+	GuildID   string
+	UserID    string
+	ChannelID ChannelID
+	Music     []Provider.Music
+
+	seq    int             // the order of the job (Id is its string)
+	ctx    context.Context // done when the job is canceled (stops the downloads of the job)
+	cancel context.CancelFunc
+	done   chan struct{} // closed when the download thread of the job exits
+	failed int           // the number of the music which failed to download (written by the download thread)
+
+	// reports the result of the cancel to the progress message (optional, set before the download thread starts)
+	onCancel func(kept int)
+}
+
+// The running import jobs (by job ID)
+var (
+	importJobsMu  sync.Mutex
+	importJobs    = map[string]*ImportJob{}
+	importJobsSeq = 0
+)
+
+// newImportJob registers the job of the music. (the caller must call finish() when the job exits)
+//
+// The music of the job is tagged, so that the job can tell its entries from the same music in the queue.
+// (the tagged music must be enqueued, see ImportJob.Music)
+func newImportJob(guildID, userID string, channelID ChannelID, m []Provider.Music) *ImportJob {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &ImportJob{
+		GuildID:   guildID,
+		UserID:    userID,
+		ChannelID: channelID,
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+
+	importJobsMu.Lock()
+	importJobsSeq++
+	job.seq = importJobsSeq
+	job.Id = strconv.Itoa(job.seq)
+	importJobs[job.Id] = job
+	importJobsMu.Unlock()
+
+	for j, v := range m {
+		v.Tag = fmt.Sprintf("import:%s:%d", job.Id, j)
+		job.Music = append(job.Music, v)
+	}
+
+	return job
+}
+
+// finish unregisters the job. (the downloads of the job are not stopped)
+func (j *ImportJob) finish() {
+	importJobsMu.Lock()
+	delete(importJobs, j.Id)
+	importJobsMu.Unlock()
+
+	close(j.done)
+}
+
+// Cancel stops the job, and removes the music of the job which is not downloaded from the queue.
+//
+// It returns the number of the music of the job that is left in the queue. (or already played)
+func (j *ImportJob) Cancel() int {
+	j.cancel()
+	<-j.done
+
+	// the music of the job which is downloaded is kept
+	// (the playing music is not removed, the player removes it when finished)
+	state := GetState(j.ChannelID)
+	removed := state.RemoveWhere(func(m Provider.Music) bool {
+		return j.isEntry(m) && !isStreaming(m) && !state.prefetcher.isDownloaded(m.Id)
+	})
+	state.Prefetch() // the downloads of the removed music are stopped (and the partial files are removed)
+
+	// the failed music is already removed by the download thread (not counted twice)
+	kept := len(j.Music) - j.failed - removed
+	if j.onCancel != nil {
+		j.onCancel(kept)
+	}
+
+	return kept
+}
+
+// isEntry reports whether the entry of the queue is added by the job. (see newImportJob)
+func (j *ImportJob) isEntry(music Provider.Music) bool {
+	return strings.HasPrefix(music.Tag, "import:"+j.Id+":")
+}
+
+// canceledMessage returns the result of the canceled job.
+func (j *ImportJob) canceledMessage(kept int) string {
+	return fmt.Sprintf("**Import #%s canceled.**\n%d of %d songs were added to the queue.", j.Id, kept, len(j.Music))
+}
+
+// findImportJob returns the running job of the user. (the latest one if id is empty)
+func findImportJob(guildID, userID, id string) *ImportJob {
+	importJobsMu.Lock()
+	defer importJobsMu.Unlock()
+
+	if id != "" {
+		job, ok := importJobs[id]
+		if !ok || job.GuildID != guildID {
+			return nil
+		}
+		return job
+	}
+
+	var latest *ImportJob
+	for _, v := range importJobs {
+		if v.GuildID != guildID || v.UserID != userID {
+			continue
+		}
+
+		if latest == nil || v.seq > latest.seq {
+			latest = v
+		}
+	}
+
+	return latest
+}
+
+// cancelButton returns the button to cancel the job.
+// (handled by onImportCancel, so the commands that start a job must call bindComponents)
+func (j *ImportJob) cancelButton() []discordgo.MessageComponent {
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    fmt.Sprintf("Cancel import #%s", j.Id),
+					Style:    discordgo.DangerButton,
+					CustomID: "musicbot.import:" + j.Id,
+				},
+			},
+		},
+	}
+}
+
+// CancelImport cancels the running import job of the user. (the latest one if the id is omitted)
+func CancelImport(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	id := ""
+	if v, ok := util.GetOptions(i)["id"]; ok {
+		id = strings.TrimPrefix(v.StringValue(), "#")
+	}
+
+	Log.Verbose.Printf("[MusicBot] Cancel command called by %s (C:%s, %s)", i.Member.User.Username, i.ChannelID, id)
+
+	job := findImportJob(i.GuildID, i.Member.User.ID, id)
+	if job == nil {
+		util.EphemeralResponse(s, i, "**There is no import to cancel!**\nThe import may be already finished.")
+		return
+	}
+
+	if job.UserID != i.Member.User.ID {
+		util.EphemeralResponse(s, i, fmt.Sprintf("**Cannot cancel import #%s!**\nOnly the user who started the import can cancel it.", job.Id))
+		return
+	}
+
+	// the cancel button of the progress message is removed by the job (see ImportJob.onCancel)
+	util.EphemeralResponse(s, i, fmt.Sprintf("**Canceling import #%s...**", job.Id))
+	util.EditResponse(s, i, job.canceledMessage(job.Cancel()))
+}
+
+// onImportCancel cancels the import job of the progress message. (payload is the job ID)
+func onImportCancel(s *discordgo.Session, i *discordgo.InteractionCreate, payload string) {
+	job := findImportJob(i.GuildID, i.Member.User.ID, payload)
+	if job != nil && job.UserID != i.Member.User.ID {
+		util.EphemeralResponse(s, i, fmt.Sprintf("**Cannot cancel import #%s!**\nOnly the user who started the import can cancel it.", job.Id))
+		return
+	}
+
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
+	})
+
+	if job == nil {
+		util.EditResponseWithComponents(s, i, "**There is no import to cancel!**\nThe import is already finished.", []*discordgo.MessageEmbed{}, []discordgo.MessageComponent{})
+		return
+	}
+
+	Log.Verbose.Printf("[MusicBot] Import #%s canceled by %s (C:%s)", job.Id, i.Member.User.Username, i.ChannelID)
+	util.EditResponseWithComponents(s, i, job.canceledMessage(job.Cancel()), []*discordgo.MessageEmbed{}, []discordgo.MessageComponent{})
+}
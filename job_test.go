@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	Provider "github.com/thirdscam/chatanium-musicbot/provider"
+)
+
+func TestImportJobCancel(t *testing.T) {
+	state := newTestState(t)
+	m := []Provider.Music{{Id: "TEST:a"}, {Id: "TEST:b"}, {Id: "TEST:c"}}
+	job := newImportJob("guild", "user", ChannelID(t.Name()), m)
+
+	// the same music is queued by another user before and after the import
+	state.Enqueue(Provider.Music{Id: "TEST:a"})
+	state.Enqueue(job.Music...)
+	state.Enqueue(Provider.Music{Id: "TEST:b"})
+
+	// "TEST:c" failed to download, and is removed by the download thread
+	job.failed = 1
+	state.RemoveWhere(func(v Provider.Music) bool { return v.Tag == job.Music[2].Tag })
+	job.finish()
+
+	if kept := job.Cancel(); kept != 0 {
+		t.Fatalf("kept %d songs, want 0", kept)
+	}
+
+	// the music of the other user is left
+	queue := state.GetQueue()
+	if len(queue) != 2 || queue[0].Tag != "" || queue[1].Tag != "" {
+		t.Fatalf("unexpected queue: %+v", queue)
+	}
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"math"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
@@ -27,26 +29,123 @@ var DEFINE_SLASHCMD = Slash.Commands{
 		Name:        "play",
 		Description: "Play music",
 		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "query",
+				Description:  "Enter a query to search (separate multiple queries with \";\")",
+				Required:     true,
+				Autocomplete: true,
+			},
 			{
 				Type:        discordgo.ApplicationCommandOptionString,
 				Name:        "provider",
-				Description: "Enter a provider of music",
+				Description: "Enter a provider of music (detected from the query if omitted)",
+				Required:    false,
+				Choices:     providerChoices(0),
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "items",
+				Description: "Enter the positions of the playlist to add (e.g. 1-10,15,20-)",
+				Required:    false,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        "limit",
+				Description: "Enter the maximum number of songs to add from the playlist",
+				Required:    false,
+				MinValue:    &searchCountMin,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionBoolean,
+				Name:        "shuffle",
+				Description: "Shuffle the songs of the playlist",
+				Required:    false,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionBoolean,
+				Name:        "reverse",
+				Description: "Add the songs of the playlist in reverse order",
+				Required:    false,
+			},
+		},
+	}: Play,
+	{
+		Name:        "playfile",
+		Description: "Play an uploaded audio file",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionAttachment,
+				Name:        "file",
+				Description: "Upload an audio file (mp3, ogg, opus, flac, wav, m4a, aac)",
+				Required:    true,
+			},
+		},
+	}: PlayFile,
+	{
+		Name:        "import",
+		Description: "Add the songs listed in an uploaded file",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionAttachment,
+				Name:        "file",
+				Description: "Upload a list of songs (.txt, .m3u or .json)",
 				Required:    true,
-				Choices: []*discordgo.ApplicationCommandOptionChoice{
-					{
-						Name:  "youtube",
-						Value: "youtube",
-					},
-				},
 			},
+		},
+	}: Import,
+	{
+		Name:        "cancel",
+		Description: "Cancel an import of songs",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "id",
+				Description: "Enter the id of the import (your latest import if omitted)",
+				Required:    false,
+			},
+		},
+	}: CancelImport,
+	{
+		Name:        "search",
+		Description: "Search music and pick one of the results",
+		Options: []*discordgo.ApplicationCommandOption{
 			{
 				Type:        discordgo.ApplicationCommandOptionString,
 				Name:        "query",
 				Description: "Enter a query to search",
 				Required:    true,
 			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "provider",
+				Description: "Enter a provider of music (default provider of this server if omitted)",
+				Required:    false,
+				Choices:     providerChoices(Provider.CapabilitySearch),
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        "count",
+				Description: "Enter the number of results (1-10)",
+				Required:    false,
+				MinValue:    &searchCountMin,
+				MaxValue:    10,
+			},
 		},
-	}: Play,
+	}: Search,
+	{
+		Name:        "provider",
+		Description: "Set the default search provider of this server",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "name",
+				Description: "Enter a provider of music",
+				Required:    true,
+				Choices:     providerChoices(Provider.CapabilitySearch),
+			},
+		},
+	}: SetProvider,
 	{
 		Name:        "remove",
 		Description: "Remove a music from playlist",
@@ -75,11 +174,78 @@ var DEFINE_SLASHCMD = Slash.Commands{
 		Name:        "loop",
 		Description: "Loop music",
 	}: Loop,
+	{
+		Name:        "chapter",
+		Description: "Skip to a chapter of the music",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        "index",
+				Description: "Enter a index of chapter (next chapter if omitted)",
+				Required:    false,
+			},
+		},
+	}: Chapter,
+	{
+		Name:        "cache",
+		Description: "Show the statistics of the music cache",
+	}: Cache,
+	{
+		// the context menu of the message (no description and options)
+		Type: discordgo.MessageApplicationCommand,
+		Name: "Play in voice",
+	}: PlayMessage,
 }
 
+// The minimum value of the count options (count of /search, limit of /play) (MinValue needs a pointer)
+var searchCountMin float64 = 1
+
 // The providers of the music (youtube, etc.)
 var providers map[string]Provider.Interface = make(map[string]Provider.Interface)
 
+// The maximum time to wait for a provider to answer a query.
+// (the child process of the provider is killed when it is exceeded)
+const QUERY_TIMEOUT = 2 * time.Minute
+
+// The time that the response of an interaction can be edited. (the token of the interaction expires after 15 minutes)
+const INTERACTION_EXPIRE = 14 * time.Minute
+
+// providerChoices returns the command choices of the registered providers that support the capabilities.
+func providerChoices(capabilities Provider.Capability) []*discordgo.ApplicationCommandOptionChoice {
+	result := []*discordgo.ApplicationCommandOptionChoice{}
+	for _, v := range Provider.Entries() {
+		if !v.Capabilities.Has(capabilities) {
+			continue
+		}
+
+		result = append(result, &discordgo.ApplicationCommandOptionChoice{
+			Name:  v.Label,
+			Value: v.Name,
+		})
+	}
+
+	return result
+}
+
+// selectProvider selects the provider to handle the query.
+//
+// The provider is selected in the following order:
+//  1. the provider given by the command option
+//  2. the provider which claims the query as its URL
+//  3. the default search provider of the guild
+func selectProvider(guildID, name, query string) (Provider.Interface, bool) {
+	if name == "" {
+		if entry, ok := Provider.Detect(query); ok {
+			name = entry.Name
+		} else {
+			name = GetDefaultProvider(guildID)
+		}
+	}
+
+	provider, ok := providers[name]
+	return provider, ok
+}
+
 func Start() {
 	Log.Verbose.Println("[MusicBot] Initializing...")
 
@@ -87,26 +253,37 @@ func Start() {
 
 	for k, v := range providers {
 		Log.Verbose.Printf("[MusicBot] Starting provider: %s", k)
-		v.Start()
+		v.Start(context.Background())
 	}
 
+	musicCache.Start()
+
 	Log.Verbose.Println("[MusicBot] Initialized.")
 }
 
 func Play(s *discordgo.Session, i *discordgo.InteractionCreate) {
-	Log.Verbose.Printf("[MusicBot] Play command called by %s (C:%s, %s)", i.Member.User.Username, i.ChannelID, i.ApplicationCommandData().Options[1].StringValue())
+	// the suggestions of the query option are requested with the same command name
+	if i.Type == discordgo.InteractionApplicationCommandAutocomplete {
+		PlayAutocomplete(s, i)
+		return
+	}
+
+	bindComponents(s) // the cancel button of the import
+
+	options := util.GetOptions(i)
+	query := options["query"].StringValue()
+
+	Log.Verbose.Printf("[MusicBot] Play command called by %s (C:%s, %s)", i.Member.User.Username, i.ChannelID, query)
 	util.EphemeralResponse(s, i, "**Adding song to queue...**\nIf you enter a playlist, it might take a while for the entire contents to import.\n(The first song will automatically play when it's ready.)")
 
-	// Get the query
-	queryType := i.ApplicationCommandData().Options[0].StringValue()
-	query := i.ApplicationCommandData().Options[1].StringValue()
+	// Get the provider (already started in Start())
+	providerName := ""
+	if v, ok := options["provider"]; ok {
+		providerName = v.StringValue()
+	}
 
-	// Get the provider
-	var provider Provider.Interface
-	switch queryType {
-	case "youtube":
-		provider = &Provider.Youtube{}
-	default:
+	provider, ok := selectProvider(i.GuildID, providerName, query)
+	if !ok {
 		util.EditResponse(s, i, "**Invalid provider name!**\nPlease input a valid provider name.")
 		return
 	}
@@ -117,14 +294,74 @@ func Play(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		return
 	}
 
+	// the multiple queries are imported line by line (e.g. "song A; song B")
+	if queries := splitQueries(query); len(queries) > 1 {
+		importQueries(s, i, channelID, queries, providerName)
+		return
+	}
+
 	// Get the music
-	m, err := provider.GetMusic(query)
+	m, skipped, err := queryMusic(provider, query)
 	if err != nil {
 		Log.Verbose.Printf("[MusicBot] Failed to query music: %s", err)
-		util.EditResponse(s, i, "**Failed to query music.**\nPlease try again or input another query.")
+		util.EditResponse(s, i, queryErrorMessage(err))
+		return
+	}
+
+	// Choose the songs of the playlist
+	m, err = playlistOptions(options).Apply(m)
+	if err != nil {
+		util.EditResponse(s, i, fmt.Sprintf("**Invalid playlist items!**\n%s (e.g. 1-10,15,20-)", err))
+		return
+	}
+
+	if len(m) == 0 {
+		util.EditResponse(s, i, "**No songs are chosen!**\nPlease check the items of the playlist.")
+		return
+	}
+
+	// the large playlist is added after the confirmation of the user
+	if PLAYLIST_CONFIRM_SIZE > 0 && len(m) > PLAYLIST_CONFIRM_SIZE {
+		confirmPlaylist(s, i, channelID, m, skipped)
 		return
 	}
 
+	enqueueMusic(s, i, channelID, m, skipped)
+}
+
+// queryMusic queries the music with the provider. (up to QUERY_TIMEOUT)
+//
+// if some entries are skipped, the rest of the result can still be played,
+// so the skipped entries are returned separately without error.
+func queryMusic(provider Provider.Interface, query string) ([]Provider.Music, []Provider.SkippedEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), QUERY_TIMEOUT)
+	defer cancel()
+
+	m, err := provider.GetMusic(ctx, query)
+
+	var partial *Provider.PartialError
+	if errors.As(err, &partial) {
+		Log.Verbose.Printf("[MusicBot] Some entries are skipped: %s", err)
+		return m, partial.Skipped, nil
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return m, []Provider.SkippedEntry{}, nil
+}
+
+// enqueueMusic joins the voice channel, downloads the music and adds them to the queue of the channel.
+// (if the player of the channel is not running, it starts playing)
+//
+// It is the common path of the commands that add music, and the progress is reported by editing the response of the interaction.
+func enqueueMusic(s *discordgo.Session, i *discordgo.InteractionCreate, channelID ChannelID, m []Provider.Music, skipped []Provider.SkippedEntry) {
+	enqueueMusicWithHeader(s, i, channelID, m, skipped, "")
+}
+
+// enqueueMusicWithHeader is enqueueMusic that keeps the header above the progress of the response. (e.g. the result of the import)
+func enqueueMusicWithHeader(s *discordgo.Session, i *discordgo.InteractionCreate, channelID ChannelID, m []Provider.Music, skipped []Provider.SkippedEntry, header string) {
 	// Join the voice channel
 	dgv, err := s.ChannelVoiceJoin(i.GuildID, string(channelID), false, true)
 	if err != nil {
@@ -134,46 +371,256 @@ func Play(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	}
 	Log.Verbose.Printf("[MusicBot] Joined voice channel: %s", channelID)
 
-	// Music download thread
-	isReady := make(chan bool) // check first music is ready
+	state := GetState(channelID)
+
+	// The import is tracked as a job, so that it can be canceled. (/cancel or the button)
+	job := newImportJob(i.GuildID, i.Member.User.ID, channelID, m)
+	m = job.Music // tagged as the entries of the job
+
+	// Enqueue all of the music at once (the music is downloaded by the player when it approaches the front of the queue)
+	state.Enqueue(m...)
+	state.Prefetch()
+	for _, v := range m {
+		AddHistory(i.GuildID, v)
+	}
+
+	// the response cannot be edited after the token of the interaction expires
+	expires, _ := discordgo.SnowflakeTimestamp(i.ID)
+	expires = expires.Add(INTERACTION_EXPIRE)
+
+	// the progress of the multiple music is shown with the cancel button
+	report := func(downloaded int, failed []Provider.SkippedEntry, isFinished bool) {
+		if time.Now().After(expires) {
+			return
+		}
+
+		// the music which failed to download is not in the queue
+		isFailed := map[Provider.MusicID]bool{}
+		for _, v := range failed {
+			isFailed[Provider.MusicID(v.Id)] = true
+		}
+
+		added := []Provider.Music{}
+		for _, v := range m {
+			if !isFailed[v.Id] {
+				added = append(added, v)
+			}
+		}
+
+		content := header + addedMessage(added)
+		if len(added) == 0 {
+			content = header + "**Cannot add any music!**\nAll of the music failed to download."
+		}
+		content = util.Truncate(content+skippedSummary(append(skipped, failed...)), MESSAGE_MAX_LENGTH-100)
+
+		if len(m) == 1 {
+			util.EditResponse(s, i, content)
+			return
+		}
+
+		components := []discordgo.MessageComponent{}
+		if !isFinished {
+			content += fmt.Sprintf("\n\n**Import #%s:** %d/%d songs downloaded (the rest is downloaded before its turn)", job.Id, downloaded, len(m))
+			components = job.cancelButton()
+		}
+		util.EditResponseWithComponents(s, i, content, []*discordgo.MessageEmbed{}, components)
+	}
+	report(0, nil, false)
+
+	// the progress message is replaced with the result of the cancel (the cancel button is removed)
+	job.onCancel = func(kept int) {
+		if time.Now().After(expires) {
+			return
+		}
+		util.EditResponseWithComponents(s, i, header+job.canceledMessage(kept), []*discordgo.MessageEmbed{}, []discordgo.MessageComponent{})
+	}
 
+	// Music download thread (watches the downloads of the music by the player)
 	go func() {
-		// Building the response message
-		var respMsg string
+		defer job.finish()
 
-		// Download the music from result of the query
+		failed := []Provider.SkippedEntry{}
+		downloaded := 0
 		for j, v := range m {
-			// Download file and save it
-			err := DownloadMusic(v.RawUrl, v.Id)
-			if err != nil {
-				util.EditResponse(s, i, "**Failed to download music.**\nPlease try again.")
-				return
+			// Wait until the music approaches the front of the queue (the removed music is not downloaded)
+			// and wait for the download of the prefetch window. (the live stream is played without download)
+			isQueued := waitForFront(job.ctx, state, v)
+
+			var err error
+			if isQueued {
+				err = state.prefetcher.wait(job.ctx, v, max(state.PositionOf(v), PRIORITY_PLAYING))
+				if err == nil {
+					Log.Verbose.Printf("[MusicBot] (%d/%d) Downloaded music: %s", j+1, len(m), v.Title)
+					downloaded++
+				}
 			}
 
-			Log.Verbose.Printf("[MusicBot] (%d/%d) Downloaded music: %s", j+1, len(m), v.Title)
-			GetState(channelID).Enqueue(v)
-
-			// Update the response message
-			if j == 0 {
-				respMsg += fmt.Sprintf("**Added to queue:**\n-> **%s**", v.Title)
-				isReady <- true // if the first music is ready, start playing
-			} else {
-				respMsg += fmt.Sprintf("\n-> %s", v.Title)
+			// the canceler reports the result of the job
+			if job.ctx.Err() != nil {
+				Log.Verbose.Printf("[MusicBot] Import #%s canceled (%d/%d)", job.Id, j, len(m))
+				return
 			}
 
-			util.EditResponse(s, i, respMsg)
+			// the music which still fails is skipped, and the rest of the music is continued
+			if err != nil {
+				Log.Warn.Printf("[MusicBot] Failed to download music (skipped): %s: %v", v.Title, err)
+				failed = append(failed, Provider.SkippedEntry{Id: string(v.Id), Title: v.Title, Reason: err})
+				job.failed = len(failed)
+				state.RemoveWhere(func(music Provider.Music) bool {
+					return music.Id == v.Id && music.Tag == v.Tag // only the entry of the job
+				})
+				state.Prefetch()
+			}
 
-			time.Sleep(time.Second * 10) // wait 10 seconds (prevent rate limit)
+			// the removed music is not counted (the result is reported at the end)
+			if isQueued || j == len(m)-1 {
+				report(downloaded, failed, j == len(m)-1)
+			}
 		}
 	}()
-	<-isReady // wait for the first music to be downloaded
 
-	// Play the music
-	if !GetState(channelID).IsPlaying() {
+	// Play the music (if the player is running, the music is played after the rest of the queue)
+	// the player downloads the music of the front, so it can start right away.
+	if !state.IsPlaying() {
 		playMusic(s, dgv)
 	}
 }
 
+// waitForFront waits until the entry enters the prefetch window. (within PREFETCH_AHEAD from the front)
+//
+// It returns false if the entry is removed from the queue, or ctx is done.
+func waitForFront(ctx context.Context, state *State, entry Provider.Music) bool {
+	for {
+		updated := state.Updated() // taken before the position, not to miss the change
+		position := state.PositionOf(entry)
+		if position < 0 {
+			return false
+		}
+
+		if position <= PREFETCH_AHEAD {
+			return true
+		}
+
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// The number of the music to show in the response of the added music.
+const ADDED_MESSAGE_SIZE = 10
+
+// addedMessage returns the list of the added music. (the rest is summarized as the count)
+func addedMessage(m []Provider.Music) string {
+	result := ""
+	for j, v := range m {
+		if j == ADDED_MESSAGE_SIZE {
+			result += fmt.Sprintf("\n(+%d more songs)", len(m)-j)
+			break
+		}
+
+		if j == 0 {
+			result += fmt.Sprintf("**Added to queue:**\n-> **%s**%s", v.Title, matchNote(v))
+		} else {
+			result += fmt.Sprintf("\n-> %s%s", v.Title, matchNote(v))
+		}
+	}
+
+	return result
+}
+
+// The number of the skipped entries to show with their reasons.
+const SKIPPED_MESSAGE_SIZE = 5
+
+// skippedSummary returns the list of the skipped entries with their reasons. (the rest is summarized as the count)
+func skippedSummary(skipped []Provider.SkippedEntry) string {
+	if len(skipped) == 0 {
+		return ""
+	}
+
+	result := fmt.Sprintf("\n\n**%d entries are skipped:**", len(skipped))
+	for j, v := range skipped {
+		if j == SKIPPED_MESSAGE_SIZE {
+			result += fmt.Sprintf("\n(+%d more)", len(skipped)-j)
+			break
+		}
+
+		title := v.Title
+		if title == "" {
+			title = v.Id
+		}
+		result += fmt.Sprintf("\n- %s: %s", util.Truncate(title, 60), skipReason(v.Reason))
+	}
+
+	return result
+}
+
+// skipReason returns the short reason of the skipped entry.
+func skipReason(err error) string {
+	if errors.Is(err, errDownloadFailed) {
+		return fmt.Sprintf("Failed to download. (retried %d times)", DOWNLOAD_RETRIES)
+	}
+
+	return shortErrorMessage(err)
+}
+
+// matchNote returns the note of the music that may not be the track the user wanted.
+// (matched from the link of another service with low confidence)
+func matchNote(m Provider.Music) string {
+	if m.MatchedFrom == "" || m.Confidence >= Provider.LowConfidence {
+		return ""
+	}
+
+	return fmt.Sprintf(" ⚠️ *(uncertain match for \"%s\")*", m.MatchedFrom)
+}
+
+// queryErrorMessage returns the message to reply when the provider failed to query the music.
+func queryErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, Provider.ErrNotFound):
+		return "**Cannot find music!**\nPlease check the query or input another query."
+	case errors.Is(err, Provider.ErrAgeRestricted):
+		return "**This music is age-restricted.**\nAge-restricted music cannot be played. Please input another query."
+	case errors.Is(err, Provider.ErrGeoBlocked):
+		return "**This music is not available in the bot's region.**\nPlease input another query."
+	case errors.Is(err, Provider.ErrRateLimited):
+		return "**The provider is rate-limiting the bot.**\nPlease wait a few minutes and try again."
+	case errors.Is(err, Provider.ErrUnavailable):
+		return "**This music is unavailable.**\n(It may be private, removed or not yet released.)"
+	case errors.Is(err, Provider.ErrPrivatePlaylist):
+		return "**This playlist is private.**\nPlease make the playlist public or unlisted and try again."
+	case errors.Is(err, Provider.ErrNotConfigured):
+		return "**This service is not configured.**\nThe bot operator has not set up the credentials to read links of this service."
+	case errors.Is(err, Provider.ErrUnsupported):
+		return "**This link is not a supported audio.**\nPlease input a link of an audio file. (mp3, ogg, opus, flac, wav, m4a, aac)"
+	case errors.Is(err, Provider.ErrTooLarge):
+		return "**This audio file is too large.**\nPlease input a smaller file."
+	case errors.Is(err, Provider.ErrNotAllowed):
+		return "**This site is not allowed.**\nThe bot operator has disabled playing music from this site."
+	case errors.Is(err, context.DeadlineExceeded):
+		return "**The query timed out.**\nPlease try again. (If you entered a large playlist, try a smaller one.)"
+	}
+
+	return "**Failed to query music.**\nPlease try again or input another query."
+}
+
+func SetProvider(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	name := util.GetOptions(i)["name"].StringValue()
+
+	entry, ok := Provider.Get(name)
+	if !ok || !entry.Capabilities.Has(Provider.CapabilitySearch) {
+		util.EphemeralResponse(s, i, "**Invalid provider name!**\nPlease input a valid provider name.")
+		return
+	}
+
+	SetDefaultProvider(i.GuildID, entry.Name)
+	Log.Verbose.Printf("[MusicBot] Default provider of %s set to %s", i.GuildID, entry.Name)
+
+	util.EphemeralResponse(s, i, fmt.Sprintf("**Default provider switched!**\nSearches without a provider will now use **%s**.", entry.Label))
+}
+
 func Remove(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	// Get the index of the music to remove
 	index, err := util.Str2Int64(i.ApplicationCommandData().Options[0].StringValue())
@@ -190,7 +637,9 @@ func Remove(s *discordgo.Session, i *discordgo.InteractionCreate) {
 		return
 	}
 
-	music, err := GetState(channelID).Remove(int(index - 1))
+	state := GetState(channelID)
+	music, err := state.Remove(int(index - 1))
+	state.Prefetch() // the download of the removed music is released
 
 	if errors.Is(err, errIndexCannotBeNegative) {
 		util.EphemeralResponse(s, i, "**Invalid index!**\nOnly positive integers are allowed.\n(The 0th song is the currently playing song, so you should use /skip)")
@@ -225,13 +674,18 @@ func List(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	}
 
 	// Create a message to send
-	respMsg := fmt.Sprintf("**Now Playing: %s**\n\nQueue:\n", queue[0].Title)
+	respMsg := fmt.Sprintf("**Now Playing: %s**\n\nQueue: (%d songs)\n", queue[0].Title, len(queue)-1)
 	for i, music := range queue {
 		if i == 0 { // if the music is the currently playing music
 			continue
 		}
 
-		respMsg += fmt.Sprintf("**#%d** - %s\n", i, music.Title)
+		line := fmt.Sprintf("**#%d** - %s\n", i, music.Title)
+		if len(respMsg)+len(line) > MESSAGE_MAX_LENGTH-50 { // the large playlist does not fit in a message
+			respMsg += fmt.Sprintf("(+%d more songs)", len(queue)-i)
+			break
+		}
+		respMsg += line
 	}
 
 	// Send a message to the channel
@@ -247,6 +701,11 @@ func Pause(s *discordgo.Session, i *discordgo.InteractionCreate) {
 
 	err := GetState(channelID).Pause()
 
+	if errors.Is(err, errNotReady) {
+		util.EphemeralResponse(s, i, "**The music is still downloading.**\nPlease try again when it starts playing.")
+		return
+	}
+
 	if errors.Is(err, errSignalTimeout) {
 		util.EphemeralResponse(s, i, "**Failed to pause/resume music.**\nPlease try again. (If the problem persists, please contact the developer.)")
 		Log.Warn.Println("[MusicBot] Failed to pause/resume music. (channel timeout)")
@@ -293,6 +752,62 @@ func Loop(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	util.EphemeralResponse(s, i, fmt.Sprintf("**Loop mode switched!**\n%s", message))
 }
 
+func Chapter(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	channelID := getChannelIdByUser(s, i.GuildID, i.Member.User.ID)
+	if channelID == "" {
+		util.EphemeralResponse(s, i, "**Failed to find voice channel.** (or you're not in a voice channel)\nPlease rejoin the voice channel and try again.")
+		return
+	}
+
+	queue := GetState(channelID).GetQueue()
+	if len(queue) == 0 {
+		util.EphemeralResponse(s, i, "**Queue is empty!**\nPlease play a song first.")
+		return
+	}
+
+	chapters := queue[0].Chapters
+	if len(chapters) == 0 {
+		util.EphemeralResponse(s, i, "**This music has no chapters.**")
+		return
+	}
+
+	// skip to the chapter of the index, or the next chapter of the current position
+	var seek SeekFunc
+	var message string
+	if v, ok := util.GetOptions(i)["index"]; ok {
+		index := int(v.IntValue())
+		if index < 1 || index > len(chapters) {
+			util.EphemeralResponse(s, i, fmt.Sprintf("**Invalid index!**\nThis music has %d chapters.", len(chapters)))
+			return
+		}
+
+		chapter := chapters[index-1]
+		seek = func(time.Duration) time.Duration { return chapter.Start }
+		message = fmt.Sprintf("**Skipped to chapter #%d:** %s", index, chapter.Title)
+	} else {
+		seek = func(position time.Duration) time.Duration {
+			for _, v := range chapters {
+				if v.Start > position+time.Second { // ignore the chapter just started
+					return v.Start
+				}
+			}
+
+			return math.MaxInt64 // no more chapters (skip to the end)
+		}
+		message = "**Skipped to the next chapter.**"
+	}
+
+	err := GetState(channelID).Seek(seek)
+
+	if errors.Is(err, errSignalTimeout) {
+		util.EphemeralResponse(s, i, "**Failed to skip to the chapter.**\nPlease try again. (If the problem persists, please contact the developer.)")
+		Log.Warn.Println("[MusicBot] Failed to seek music. (channel timeout)")
+		return
+	}
+
+	util.EphemeralResponse(s, i, message)
+}
+
 func playMusic(s *discordgo.Session, dgv *discordgo.VoiceConnection) {
 	for {
 		// Get the state of the channel
@@ -305,6 +820,7 @@ func playMusic(s *discordgo.Session, dgv *discordgo.VoiceConnection) {
 		// Check if the queue is empty
 		if state.IsQueueEmpty() {
 			state.SetIsPlaying(false)
+			state.prefetcher.update(nil) // release all of the prefetched music
 			err := dgv.Disconnect()
 			if err != nil {
 				Log.Warn.Printf("[MusicBot] Failed to disconnect from voice channel: %v", err)
@@ -317,42 +833,108 @@ func playMusic(s *discordgo.Session, dgv *discordgo.VoiceConnection) {
 		// Set a message to the channel
 		SetStatusEmbed(s, dgv.ChannelID, EmbedState{
 			Title:        nowMusic.Title,
-			ThumbnailUrl: nowMusic.RawUrl,
+			ThumbnailUrl: nowMusic.ThumbnailUrl,
+			Artwork:      getArtwork(nowMusic),
 		})
 
-		// Start playing the music
-		Log.Info.Printf("[MusicBot] Playing music: %s", nowMusic.Title)
-		PlayMusic(dgv, nowMusic.Id, state.pause, state.skip)
-
-		util.WithRLock(&state.RWMutex, func() {
-			// Remove the first element from the queue
-			front := state.Pop()
-
-			if state.loop {
-				// Add the first element to the end of the queue
-				state.queue = append(state.queue, front)
+		// Keep the playing music and the next music downloaded (the playback waits for the download of the front)
+		state.Prefetch()
+		err := state.waitFront(nowMusic)
+		switch {
+		case err == nil:
+			// Start playing the music
+			Log.Info.Printf("[MusicBot] Playing music: %s", nowMusic.Title)
+			if isStreaming(nowMusic) {
+				streamMusic(s, dgv, nowMusic, state)
+			} else {
+				PlayMusic(dgv, nowMusic.Id, state.pause, state.skip, state.seek)
 			}
 
-			// Ignore removing songs after scanning if the same song is in the queue
-			isDupilcated := false
-			for _, m := range state.queue {
-				if m.Id == front.Id {
-					isDupilcated = true
-				}
+		case errors.Is(err, context.Canceled): // skipped or removed before the download is finished
+			Log.Verbose.Printf("[MusicBot] Download skipped: %s", nowMusic.Title)
+
+		default: // the music cannot be played, so it is dequeued even in loop mode
+			Log.Warn.Printf("[MusicBot] Failed to download music (skipped): %s: %v", nowMusic.Title, err)
+			state.Lock()
+			if len(state.queue) > 0 && state.queue[0].Id == nowMusic.Id {
+				state.Pop()
 			}
+			state.Unlock()
+			continue
+		}
 
-			// if the same song is not in the queue, remove the music.
-			// also if loop mode is on, the same song will be at the end of the queue, so it won't be removed.
-			if !isDupilcated {
-				RemoveMusic(nowMusic.Id)
+		// the file of the music is not removed here, but left to the cache.
+		// (it is kept while any queue has the music, and evicted later if no queue has it)
+		state.Lock()
+		if len(state.queue) > 0 && state.queue[0].Id == nowMusic.Id { // not removed by /remove
+			if state.loop {
+				// Move the first element to the end of the queue (it stays in the queue)
+				state.queue = append(state.queue[1:], state.queue[0])
+				state.notifyUpdate()
+			} else {
+				// Remove the first element from the queue
+				state.Pop()
 			}
-		})
+		}
+		state.Unlock()
 
 		// Play the next song
 		time.Sleep(1 * time.Second)
 	}
 }
 
+// isStreaming reports whether the music is a live stream that is played without download.
+func isStreaming(music Provider.Music) bool {
+	_, ok := providers[music.Type].(Provider.Streamer)
+	return ok && music.IsLive
+}
+
+// streamMusic plays the live stream of the music,
+// and updates the status embed whenever the current song of the stream changes.
+func streamMusic(s *discordgo.Session, dgv *discordgo.VoiceConnection, music Provider.Music, state *State) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	onTitle := func(title string) {
+		Log.Verbose.Printf("[MusicBot] Stream title changed: %s", title)
+		SetStatusEmbed(s, dgv.ChannelID, EmbedState{
+			Title:        fmt.Sprintf("%s\n-> **%s**", music.Title, title),
+			ThumbnailUrl: music.ThumbnailUrl,
+		})
+	}
+
+	stream, err := providers[music.Type].(Provider.Streamer).Stream(ctx, music, onTitle)
+	if err != nil {
+		Log.Error.Printf("[MusicBot] Failed to open stream: %v", err)
+		return
+	}
+
+	StreamMusic(dgv, stream, state.pause, state.skip)
+}
+
+// getArtwork returns the cover art of the music if the provider has it. (only for the music without thumbnail URL)
+func getArtwork(music Provider.Music) []byte {
+	if music.ThumbnailUrl != "" {
+		return nil
+	}
+
+	provider, ok := providers[music.Type].(Provider.ArtworkProvider)
+	if !ok {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), RESOLVE_TIMEOUT)
+	defer cancel()
+
+	artwork, err := provider.Artwork(ctx, music)
+	if err != nil {
+		Log.Verbose.Printf("[MusicBot] No artwork of the music: %v", err)
+		return nil
+	}
+
+	return artwork
+}
+
 func getChannelIdByUser(s *discordgo.Session, guildID, userID string) ChannelID {
 	// Get the voice state of the user
 	guild, err := s.State.Guild(guildID)
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"regexp"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	Provider "github.com/thirdscam/chatanium-musicbot/provider"
+	"github.com/thirdscam/chatanium-musicbot/util"
+	"github.com/thirdscam/chatanium/src/Util/Log"
+)
+
+// The links in the content of the message (the brackets of "<url>" suppress the embed, and are not a part of the link)
+var messageUrlPattern = regexp.MustCompile(`https?://[^\s<>]+`)
+
+// PlayMessage plays every supported link of the message. ("Play in voice" of the message context menu)
+//
+// The links are collected from the content, the embeds and the audio attachments of the message,
+// and each of them is handled by the provider which claims it.
+func PlayMessage(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	bindComponents(s) // the cancel button of the import
+
+	data := i.ApplicationCommandData()
+	message := data.Resolved.Messages[data.TargetID]
+	if message == nil {
+		util.EphemeralResponse(s, i, "**Cannot find the message!**\nPlease try again.")
+		return
+	}
+
+	Log.Verbose.Printf("[MusicBot] Play in voice called by %s (C:%s, M:%s)", i.Member.User.Username, i.ChannelID, message.ID)
+
+	urls := messageUrls(message)
+	if len(urls) == 0 {
+		util.EphemeralResponse(s, i, "**There is no link to play in this message.**")
+		return
+	}
+
+	util.EphemeralResponse(s, i, fmt.Sprintf("**Adding %d links to queue...**\n(The first song will automatically play when it's ready.)", len(urls)))
+
+	channelID := getChannelIdByUser(s, i.GuildID, i.Member.User.ID)
+	if channelID == "" {
+		util.EditResponse(s, i, "**Failed to join voice channel.**\nPlease rejoin the voice channel and try again. (or you're not in a voice channel)")
+		return
+	}
+
+	// Get the music of each link (the unsupported or broken links are skipped)
+	m := []Provider.Music{}
+	skipped := []Provider.SkippedEntry{}
+	for _, url := range urls {
+		entry, ok := Provider.Detect(url)
+		if !ok {
+			continue
+		}
+
+		provider, ok := providers[entry.Name]
+		if !ok {
+			continue
+		}
+
+		result, resultSkipped, err := queryMusic(provider, url)
+		if err != nil {
+			Log.Verbose.Printf("[MusicBot] Failed to query music: %s", err)
+			skipped = append(skipped, Provider.SkippedEntry{Title: url, Reason: err})
+			continue
+		}
+
+		m = append(m, result...)
+		skipped = append(skipped, resultSkipped...)
+	}
+
+	if len(m) == 0 {
+		util.EditResponse(s, i, "**Cannot find music in this message!**\nThe links are not supported or unavailable.")
+		return
+	}
+
+	enqueueMusic(s, i, channelID, m, skipped)
+}
+
+// messageUrls returns the links of the message without duplicates. (in the order of appearance)
+func messageUrls(message *discordgo.Message) []string {
+	result := []string{}
+	seen := map[string]bool{}
+	add := func(url string) {
+		url = strings.TrimRight(url, ".,;:!?)]}'\"") // punctuation of the sentence
+		if url == "" || seen[url] {
+			return
+		}
+		seen[url] = true
+		result = append(result, url)
+	}
+
+	for _, v := range messageUrlPattern.FindAllString(message.Content, -1) {
+		add(v)
+	}
+
+	// the images of the embeds are not music, but the pages can be
+	// (the video URL of the embed is the player of the same page, so it is not added)
+	for _, v := range message.Embeds {
+		if v.Type == discordgo.EmbedTypeImage || v.Type == discordgo.EmbedTypeGifv {
+			continue
+		}
+		add(v.URL)
+	}
+
+	// only the audio files of the attachments (not images, documents, etc.)
+	for _, v := range message.Attachments {
+		contentType, _, _ := mime.ParseMediaType(v.ContentType)
+		if strings.HasPrefix(contentType, "audio/") || Provider.IsAudioUrl(v.URL) {
+			add(v.URL)
+		}
+	}
+
+	return result
+}
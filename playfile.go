@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"mime"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	Provider "github.com/thirdscam/chatanium-musicbot/provider"
+	"github.com/thirdscam/chatanium-musicbot/util"
+	"github.com/thirdscam/chatanium/src/Util/Log"
+)
+
+// PlayFile plays the audio file uploaded with the command.
+//
+// The attachment is a direct link of the file on the CDN of Discord,
+// so it is probed and downloaded by the direct link provider. (tags, size limit, etc.)
+func PlayFile(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	bindComponents(s) // the cancel button of the import
+
+	options := util.GetOptions(i)
+	attachment := i.ApplicationCommandData().Resolved.Attachments[options["file"].Value.(string)]
+	if attachment == nil {
+		util.EphemeralResponse(s, i, "**Cannot find the uploaded file!**\nPlease upload the file again.")
+		return
+	}
+
+	Log.Verbose.Printf("[MusicBot] PlayFile command called by %s (C:%s, %s)", i.Member.User.Username, i.ChannelID, attachment.Filename)
+
+	// Check the type and size before downloading anything
+	provider, ok := providers["http"]
+	if !ok {
+		util.EphemeralResponse(s, i, "**Playing uploaded files is not available.**")
+		return
+	}
+
+	contentType, _, _ := mime.ParseMediaType(attachment.ContentType)
+	if !strings.HasPrefix(contentType, "audio/") && !Provider.IsAudioUrl(attachment.URL) {
+		util.EphemeralResponse(s, i, "**This file is not a supported audio.**\nPlease upload an audio file. (mp3, ogg, opus, flac, wav, m4a, aac)")
+		return
+	}
+
+	if h, ok := provider.(*Provider.Http); ok && h.MaxSize > 0 && int64(attachment.Size) > h.MaxSize {
+		util.EphemeralResponse(s, i, "**This audio file is too large.**\nPlease upload a smaller file.")
+		return
+	}
+
+	util.EphemeralResponse(s, i, "**Adding file to queue...**\n(It will automatically play when it's ready.)")
+
+	channelID := getChannelIdByUser(s, i.GuildID, i.Member.User.ID)
+	if channelID == "" {
+		util.EditResponse(s, i, "**Failed to join voice channel.**\nPlease rejoin the voice channel and try again. (or you're not in a voice channel)")
+		return
+	}
+
+	// Read the tags of the file (it also checks that the file has an audio stream)
+	ctx, cancel := context.WithTimeout(context.Background(), QUERY_TIMEOUT)
+	m, err := provider.GetMusic(ctx, attachment.URL)
+	cancel()
+	if err != nil {
+		Log.Verbose.Printf("[MusicBot] Failed to probe the uploaded file: %s", err)
+		if errors.Is(err, Provider.ErrUnsupported) {
+			util.EditResponse(s, i, "**This file is not a supported audio.**\nPlease upload an audio file. (mp3, ogg, opus, flac, wav, m4a, aac)")
+			return
+		}
+		util.EditResponse(s, i, queryErrorMessage(err))
+		return
+	}
+
+	// the uploader is shown instead of the host of the CDN
+	for j := range m {
+		m[j].Uploader = i.Member.User.Username
+	}
+
+	enqueueMusic(s, i, channelID, m, nil)
+}
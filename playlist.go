@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	Provider "github.com/thirdscam/chatanium-musicbot/provider"
+	"github.com/thirdscam/chatanium-musicbot/util"
+	"github.com/thirdscam/chatanium/src/Util/Log"
+)
+
+// The number of songs of a playlist above which the user must confirm to add it.
+// (MUSICBOT_PLAYLIST_CONFIRM_SIZE overrides it, 0 disables the confirmation)
+var PLAYLIST_CONFIRM_SIZE = 50
+
+// The time to wait for the confirmation of the playlist.
+const PLAYLIST_CONFIRM_EXPIRE = 10 * time.Minute
+
+func init() {
+	if v, err := strconv.Atoi(os.Getenv("MUSICBOT_PLAYLIST_CONFIRM_SIZE")); err == nil && v >= 0 {
+		PLAYLIST_CONFIRM_SIZE = v
+	}
+}
+
+// PlaylistOptions are the options of /play to choose the songs of a playlist.
+//
+// They are applied in the order of the fields. (e.g. reverse and limit takes the last songs)
+type PlaylistOptions struct {
+	Items   string // the positions of the songs to add (e.g. "1-10,15,20-")
+	Reverse bool
+	Shuffle bool
+	Limit   int // the maximum number of songs (0 is unlimited)
+}
+
+// playlistOptions reads the playlist options of the command.
+func playlistOptions(options map[string]*discordgo.ApplicationCommandInteractionDataOption) PlaylistOptions {
+	result := PlaylistOptions{}
+	if v, ok := options["items"]; ok {
+		result.Items = v.StringValue()
+	}
+	if v, ok := options["reverse"]; ok {
+		result.Reverse = v.BoolValue()
+	}
+	if v, ok := options["shuffle"]; ok {
+		result.Shuffle = v.BoolValue()
+	}
+	if v, ok := options["limit"]; ok {
+		result.Limit = int(v.IntValue())
+	}
+
+	return result
+}
+
+// Apply returns the songs of the playlist chosen by the options.
+func (o PlaylistOptions) Apply(m []Provider.Music) ([]Provider.Music, error) {
+	result := append([]Provider.Music{}, m...)
+
+	if o.Items != "" {
+		indexes, err := parsePlaylistItems(o.Items, len(m))
+		if err != nil {
+			return nil, err
+		}
+
+		result = []Provider.Music{}
+		for _, v := range indexes {
+			result = append(result, m[v])
+		}
+	}
+
+	if o.Reverse {
+		for a, b := 0, len(result)-1; a < b; a, b = a+1, b-1 {
+			result[a], result[b] = result[b], result[a]
+		}
+	}
+
+	if o.Shuffle {
+		rand.Shuffle(len(result), func(a, b int) {
+			result[a], result[b] = result[b], result[a]
+		})
+	}
+
+	if o.Limit > 0 && len(result) > o.Limit {
+		result = result[:o.Limit]
+	}
+
+	return result, nil
+}
+
+// parsePlaylistItems returns the indexes of the positions. (like --playlist-items of yt-dlp)
+//
+// The positions start from 1, and are separated by commas. (e.g. "1-10,15,20-")
+// the positions out of the playlist are ignored.
+func parsePlaylistItems(items string, count int) ([]int, error) {
+	result := []int{}
+	for _, v := range strings.Split(items, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		start, end, isRange := strings.Cut(v, "-")
+		from, err := strconv.Atoi(strings.TrimSpace(start))
+		if err != nil || from < 1 {
+			return nil, fmt.Errorf("invalid position: %q", v)
+		}
+
+		to := from
+		if isRange {
+			to = count // "20-" is to the end of the playlist
+			if end = strings.TrimSpace(end); end != "" {
+				if to, err = strconv.Atoi(end); err != nil || to < from {
+					return nil, fmt.Errorf("invalid range: %q", v)
+				}
+			}
+		}
+
+		for j := from; j <= to && j <= count; j++ {
+			result = append(result, j-1)
+		}
+	}
+
+	return result, nil
+}
+
+// pendingPlaylist is a playlist waiting for the confirmation of the user.
+type pendingPlaylist struct {
+	channelID ChannelID
+	music     []Provider.Music
+	skipped   []Provider.SkippedEntry
+}
+
+// The playlists waiting for the confirmation (by interaction ID)
+var (
+	pendingPlaylistsMu sync.Mutex
+	pendingPlaylists   = map[string]pendingPlaylist{}
+)
+
+// confirmPlaylist asks the user whether to add the large playlist. (the buttons are handled by onPlaylistConfirm)
+func confirmPlaylist(s *discordgo.Session, i *discordgo.InteractionCreate, channelID ChannelID, m []Provider.Music, skipped []Provider.SkippedEntry) {
+	bindComponents(s)
+
+	util.WithLock(&pendingPlaylistsMu, func() {
+		pendingPlaylists[i.ID] = pendingPlaylist{channelID: channelID, music: m, skipped: skipped}
+	})
+	time.AfterFunc(PLAYLIST_CONFIRM_EXPIRE, func() {
+		util.WithLock(&pendingPlaylistsMu, func() {
+			delete(pendingPlaylists, i.ID)
+		})
+	})
+
+	content := fmt.Sprintf("**This playlist has %d songs.**\nDo you want to add all of them to the queue?\n(Use the `items` or `limit` option of `/play` to add a part of the playlist.)", len(m))
+	util.EditResponseWithComponents(s, i, content, []*discordgo.MessageEmbed{}, []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    fmt.Sprintf("Add %d songs", len(m)),
+					Style:    discordgo.PrimaryButton,
+					CustomID: "musicbot.playlist:" + i.ID + ":add",
+				},
+				discordgo.Button{
+					Label:    "Cancel",
+					Style:    discordgo.SecondaryButton,
+					CustomID: "musicbot.playlist:" + i.ID + ":cancel",
+				},
+			},
+		},
+	})
+}
+
+// onPlaylistConfirm adds or discards the pending playlist. (payload is "<interaction ID of /play>:<add|cancel>")
+func onPlaylistConfirm(s *discordgo.Session, i *discordgo.InteractionCreate, payload string) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
+	})
+
+	id, action, _ := strings.Cut(payload, ":")
+
+	var pending pendingPlaylist
+	var ok bool
+	util.WithLock(&pendingPlaylistsMu, func() {
+		pending, ok = pendingPlaylists[id]
+		delete(pendingPlaylists, id) // the buttons can be pressed only once
+	})
+
+	if !ok {
+		util.EditResponseWithComponents(s, i, "**The playlist expired!**\nPlease input the playlist again.", []*discordgo.MessageEmbed{}, []discordgo.MessageComponent{})
+		return
+	}
+
+	if action != "add" {
+		util.EditResponseWithComponents(s, i, "**Canceled.**", []*discordgo.MessageEmbed{}, []discordgo.MessageComponent{})
+		return
+	}
+
+	Log.Verbose.Printf("[MusicBot] Playlist confirmed by %s (C:%s, %d songs)", i.Member.User.Username, i.ChannelID, len(pending.music))
+	util.EditResponseWithComponents(s, i, "**Adding songs to queue...**\n(The first song will automatically play when it's ready.)", []*discordgo.MessageEmbed{}, []discordgo.MessageComponent{})
+
+	enqueueMusic(s, i, pending.channelID, pending.music, pending.skipped)
+}
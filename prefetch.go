@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+
+	Provider "github.com/thirdscam/chatanium-musicbot/provider"
+	"github.com/thirdscam/chatanium/src/Util/Log"
+)
+
+// The number of the music after the playing one to keep downloaded. (MUSICBOT_PREFETCH_AHEAD overrides it)
+// (the rest of the queue is downloaded when it approaches the front)
+var PREFETCH_AHEAD = 2
+
+func init() {
+	if v, err := strconv.Atoi(os.Getenv("MUSICBOT_PREFETCH_AHEAD")); err == nil && v >= 0 {
+		PREFETCH_AHEAD = v
+	}
+}
+
+// prefetchTask is the download of a music in the prefetch window.
+type prefetchTask struct {
+	cancel context.CancelFunc
+	done   chan struct{} // closed when the download is finished (or failed)
+	err    error
+}
+
+// prefetcher keeps the music of the prefetch window (the playing music and the next PREFETCH_AHEAD music) downloaded.
+//
+// The player updates the window whenever the playback begins or the queue is changed,
+// and the music that falls out of the window is released.
+// (the download that is not ready to play is stopped, and the rest is encoded to the end and left to the cache)
+type prefetcher struct {
+	mu    sync.Mutex
+	tasks map[Provider.MusicID]*prefetchTask
+}
+
+func newPrefetcher() *prefetcher {
+	return &prefetcher{tasks: map[Provider.MusicID]*prefetchTask{}}
+}
+
+// fetch starts downloading the music, if it is not downloaded or being downloaded. (the lower priority is started first)
+//
+// It returns nil if the music is a live stream, which is not downloaded.
+func (p *prefetcher) fetch(music Provider.Music, priority int) *prefetchTask {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if task, ok := p.tasks[music.Id]; ok {
+		return task
+	}
+
+	if isStreaming(music) {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	task := &prefetchTask{cancel: cancel, done: make(chan struct{})}
+	p.tasks[music.Id] = task
+
+	// the cached file is used as it is (the task is kept, so the hit is counted once in the window)
+	if isExistMusic(music.Id) {
+		musicCache.hit(music.Id)
+		close(task.done)
+		return task
+	}
+
+	go func() {
+		defer close(task.done)
+
+		task.err = DownloadMusicWithRetry(ctx, music, priority)
+		if task.err != nil && ctx.Err() == nil {
+			Log.Warn.Printf("[MusicBot] Failed to prefetch music: %s: %v", music.Title, task.err)
+		}
+	}()
+
+	return task
+}
+
+// wait downloads the music and waits for the result. (shared with the prefetch of the player)
+func (p *prefetcher) wait(ctx context.Context, music Provider.Music, priority int) error {
+	task := p.fetch(music, priority)
+	if task == nil {
+		return nil
+	}
+
+	select {
+	case <-task.done:
+		return task.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// update downloads the music of the window, and releases the music out of the window.
+func (p *prefetcher) update(window []Provider.Music) {
+	isInWindow := map[Provider.MusicID]bool{}
+	for j, v := range window {
+		isInWindow[v.Id] = true
+		p.fetch(v, j)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for id, task := range p.tasks {
+		if isInWindow[id] {
+			continue
+		}
+
+		// the partially downloaded file is removed by the download itself,
+		// and the music ready to play is encoded to the end and left to the cache. (see DownloadMusic, audioCache)
+		task.cancel()
+		delete(p.tasks, id)
+	}
+}
+
+// isDownloaded reports whether the prefetcher has downloaded the music successfully.
+func (p *prefetcher) isDownloaded(musicId Provider.MusicID) bool {
+	p.mu.Lock()
+	task, ok := p.tasks[musicId]
+	p.mu.Unlock()
+
+	if !ok {
+		return isExistMusic(musicId)
+	}
+
+	select {
+	case <-task.done:
+		return task.err == nil
+	default:
+		return false
+	}
+}
+
+// waitFront waits for the download of the music at the front of the queue.
+// (canceled by /skip, or /remove of the music)
+func (s *State) waitFront(music Provider.Music) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s.Lock()
+	s.cancelFront = cancel
+	s.Unlock()
+
+	defer func() {
+		s.Lock()
+		s.cancelFront = nil
+		s.Unlock()
+	}()
+
+	return s.prefetcher.wait(ctx, music, PRIORITY_PLAYING)
+}
+
+// Prefetch updates the prefetch window of the queue. (only while the player is running)
+//
+// It must be called after the queue is changed. (the music out of the window is released)
+func (s *State) Prefetch() {
+	s.RLock()
+	if !s.isPlaying {
+		s.RUnlock()
+		return
+	}
+	window := append([]Provider.Music{}, s.queue[:min(len(s.queue), PREFETCH_AHEAD+1)]...)
+	s.RUnlock()
+
+	s.prefetcher.update(window)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	Provider "github.com/thirdscam/chatanium-musicbot/provider"
+)
+
+func TestPrefetcherUpdate(t *testing.T) {
+	useMusicPath(t, 1<<30, time.Hour)
+	p := newPrefetcher()
+
+	// the music is cached, so the prefetcher does not download it
+	music := []Provider.Music{{Id: testMusicId("a")}, {Id: testMusicId("b")}, {Id: testMusicId("c")}}
+	for _, v := range music {
+		os.WriteFile(getMusicPath(v.Id), []byte("opus"), 0o644)
+	}
+
+	canceled := map[Provider.MusicID]bool{}
+	for _, v := range music {
+		task := p.fetch(v, 0)
+		task.cancel = func() { canceled[v.Id] = true }
+	}
+
+	tests := []struct {
+		window   []Provider.Music
+		canceled []Provider.MusicID // canceled by this update
+	}{
+		{music, nil},
+		{music[1:], []Provider.MusicID{music[0].Id}}, // the playing music is skipped
+		{music[2:], []Provider.MusicID{music[1].Id}}, // and the next one
+		{music, nil}, // the released music is fetched again
+		{music[:1], []Provider.MusicID{music[1].Id, music[2].Id}}, // the queue is cleared
+	}
+
+	for j, tt := range tests {
+		clear(canceled)
+		p.update(tt.window)
+
+		if len(canceled) != len(tt.canceled) {
+			t.Errorf("#%d: canceled %v, want %v", j, canceled, tt.canceled)
+		}
+		for _, id := range tt.canceled {
+			if !canceled[id] {
+				t.Errorf("#%d: %s is not canceled", j, id)
+			}
+		}
+
+		if len(p.tasks) != len(tt.window) {
+			t.Errorf("#%d: %d tasks, want %d", j, len(p.tasks), len(tt.window))
+		}
+		for _, v := range tt.window {
+			if !p.isDownloaded(v.Id) {
+				t.Errorf("#%d: %s is not ready", j, v.Id)
+			}
+		}
+
+		// the cancel of the music fetched again is recorded again
+		for _, v := range tt.window {
+			p.tasks[v.Id].cancel = func() { canceled[v.Id] = true }
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package Provider
+
+import "errors"
+
+// Errors returned by the providers.
+//
+// Providers should wrap these errors (e.g. fmt.Errorf("%w: ...", ErrNotFound))
+// so that the caller can tell the reason of the failure with errors.Is().
+var (
+	ErrNotFound        = errors.New("music not found")
+	ErrAgeRestricted   = errors.New("music is age-restricted")
+	ErrGeoBlocked      = errors.New("music is not available in this region")
+	ErrRateLimited     = errors.New("provider is rate-limited")
+	ErrUnavailable     = errors.New("music is unavailable")
+	ErrPrivatePlaylist = errors.New("playlist is private")
+	ErrUnsupported     = errors.New("media is not a supported audio")
+	ErrTooLarge        = errors.New("media is too large")
+	ErrNotAllowed      = errors.New("site is not allowed by the operator")
+	ErrNotConfigured   = errors.New("service is not configured by the operator")
+)
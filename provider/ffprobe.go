@@ -0,0 +1,124 @@
+package Provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// probeResult is the metadata of the media read by ffprobe.
+type probeResult struct {
+	Duration   time.Duration
+	FormatName string
+	Tags       map[string]string // keys are lowercased (ID3, Vorbis and MP4 tags have different cases)
+	Chapters   []Chapter
+	HasArtwork bool // the media has an attached picture (cover art)
+}
+
+// ffprobeOutput is a part of the JSON output of ffprobe that is used by the providers.
+type ffprobeOutput struct {
+	Format struct {
+		FormatName string            `json:"format_name"`
+		Duration   string            `json:"duration"`
+		Tags       map[string]string `json:"tags"`
+	} `json:"format"`
+	Streams []struct {
+		CodecType   string            `json:"codec_type"`
+		Disposition map[string]int    `json:"disposition"`
+		Tags        map[string]string `json:"tags"`
+	} `json:"streams"`
+	Chapters []struct {
+		StartTime string            `json:"start_time"`
+		EndTime   string            `json:"end_time"`
+		Tags      map[string]string `json:"tags"`
+	} `json:"chapters"`
+}
+
+// ffprobe reads the metadata of the media. (target can be a file path or a URL)
+// target is given as the input option, so it is never parsed as an option. (e.g. "-version")
+func ffprobe(ctx context.Context, target string) (probeResult, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-print_format", "json", "-show_format", "-show_streams", "-show_chapters", "-i", target)
+	r, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return probeResult{}, ctx.Err()
+		}
+		return probeResult{}, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	var output ffprobeOutput
+	if err := json.Unmarshal(r, &output); err != nil {
+		return probeResult{}, fmt.Errorf("invalid ffprobe output: %w", err)
+	}
+
+	result := probeResult{
+		Duration:   parseSeconds(output.Format.Duration),
+		FormatName: output.Format.FormatName,
+		Tags:       map[string]string{},
+		Chapters:   []Chapter{},
+	}
+
+	for k, v := range output.Format.Tags {
+		result.Tags[strings.ToLower(k)] = v
+	}
+
+	for _, v := range output.Streams {
+		// the tags of ogg/opus files are stored in the audio stream
+		if v.CodecType == "audio" {
+			for k, tag := range v.Tags {
+				if _, exists := result.Tags[strings.ToLower(k)]; !exists {
+					result.Tags[strings.ToLower(k)] = tag
+				}
+			}
+		}
+
+		if v.CodecType == "video" && v.Disposition["attached_pic"] == 1 {
+			result.HasArtwork = true
+		}
+	}
+
+	for _, v := range output.Chapters {
+		result.Chapters = append(result.Chapters, Chapter{
+			Title: v.Tags["title"],
+			Start: parseSeconds(v.StartTime),
+			End:   parseSeconds(v.EndTime),
+		})
+	}
+
+	return result, nil
+}
+
+// ffmpegArtwork extracts the attached picture (cover art) of the media.
+func ffmpegArtwork(ctx context.Context, target string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-v", "error", "-i", target, "-an", "-map", "0:v:0", "-c:v", "copy", "-frames:v", "1", "-f", "image2", "pipe:1")
+	r, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %w", err)
+	}
+
+	return r, nil
+}
+
+// parseSeconds parses the seconds as string (ffprobe format) into time.Duration.
+func parseSeconds(s string) time.Duration {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+
+	return seconds(f)
+}
+
+// parseTrackNumber parses the track (or disc) number of the tags. (e.g. "3", "3/12")
+func parseTrackNumber(s string) int {
+	n, err := strconv.Atoi(strings.TrimSpace(strings.SplitN(s, "/", 2)[0]))
+	if err != nil {
+		return 0
+	}
+
+	return n
+}
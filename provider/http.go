@@ -0,0 +1,306 @@
+package Provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	Url "net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thirdscam/chatanium-musicbot/util"
+	"github.com/thirdscam/chatanium/src/Util/Log"
+)
+
+// The audio file extensions that the http provider claims.
+var httpExtensions = map[string]bool{
+	".mp3":  true,
+	".ogg":  true,
+	".oga":  true,
+	".opus": true,
+	".flac": true,
+	".wav":  true,
+	".m4a":  true,
+	".aac":  true,
+}
+
+// The default maximum size of the media. (MUSICBOT_HTTP_MAX_SIZE overrides it, in bytes)
+const httpDefaultMaxSize = 100 * 1024 * 1024
+
+// The maximum time to probe the media.
+const httpProbeTimeout = 15 * time.Second
+
+// Http plays the audio files linked directly. (e.g. https://example.com/song.mp3)
+//
+// The size of the file is limited by MaxSize, so the file of unknown size is rejected,
+// and the download is stopped when the server sends more than MaxSize. (see Open)
+type Http struct {
+	MaxSize int64
+	Client  *http.Client
+}
+
+// errIcyStream is returned by probe when the URL is an internet radio stream. (Icecast/SHOUTcast)
+var errIcyStream = errors.New("url is an internet radio stream")
+
+func init() {
+	maxSize := int64(httpDefaultMaxSize)
+	if v, err := strconv.ParseInt(os.Getenv("MUSICBOT_HTTP_MAX_SIZE"), 10, 64); err == nil && v > 0 {
+		maxSize = v
+	}
+
+	Register(Entry{
+		Name:         "http",
+		Label:        "Direct link",
+		Capabilities: CapabilityUrl,
+		Provider: &Http{
+			MaxSize: maxSize,
+			Client:  &http.Client{Timeout: httpProbeTimeout},
+		},
+		Match: IsAudioUrl,
+	})
+}
+
+// IsAudioUrl reports whether the URL is a http(s) link of an audio file. (by the extension of its path)
+func IsAudioUrl(url string) bool {
+	u, err := Url.ParseRequestURI(url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+
+	return httpExtensions[strings.ToLower(path.Ext(u.Path))]
+}
+
+func (h *Http) Start(ctx context.Context) {
+	Log.Verbose.Printf("[MusicBot] Direct link provider started (max size: %d bytes)", h.MaxSize)
+}
+
+func (h *Http) GetMusic(ctx context.Context, query string) ([]Music, error) {
+	music, err := h.probe(ctx, query)
+
+	// the radio stream never ends, so it is played live by the radio provider (e.g. Icecast ".../stream.mp3")
+	if errors.Is(err, errIcyStream) {
+		if entry, ok := Get("radio"); ok {
+			Log.Verbose.Printf("[MusicBot] Direct link is a radio stream, passing to the radio provider: %s", query)
+			return entry.Provider.GetMusic(ctx, query)
+		}
+		return nil, fmt.Errorf("%w: internet radio stream (url: %s)", ErrUnsupported, util.RedactUrl(query))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []Music{music}, nil
+}
+
+// Search only accepts a URL, because there is nothing to search.
+func (h *Http) Search(ctx context.Context, query string, limit int) ([]Music, error) {
+	return h.GetMusic(ctx, query)
+}
+
+func (h *Http) Resolve(ctx context.Context, music Music) (Music, error) {
+	music.RawUrl = music.WebpageUrl
+	return music, nil
+}
+
+// Open downloads the media of the URL. (see Downloader)
+//
+// The size in the headers can be wrong (or missing), so the body is counted while it is read,
+// and reading it fails with ErrTooLarge when it exceeds MaxSize.
+func (h *Http) Open(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+
+	// the probe client has a timeout, which would stop the long download (the context stops it instead)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, redactError(err))
+	}
+
+	if resp.StatusCode >= 400 {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+			return nil, fmt.Errorf("%w: HTTP %d (url: %s)", ErrNotFound, resp.StatusCode, util.RedactUrl(url))
+		}
+		return nil, fmt.Errorf("%w: HTTP %d (url: %s)", ErrUnavailable, resp.StatusCode, util.RedactUrl(url))
+	}
+
+	if h.MaxSize > 0 && resp.ContentLength > h.MaxSize {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %d bytes (max: %d bytes)", ErrTooLarge, resp.ContentLength, h.MaxSize)
+	}
+
+	return &limitedBody{ReadCloser: resp.Body, maxSize: h.MaxSize}, nil
+}
+
+// limitedBody is the body of the response that fails with ErrTooLarge after maxSize bytes. (no limit if maxSize <= 0)
+type limitedBody struct {
+	io.ReadCloser
+	maxSize int64
+	read    int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+
+	if b.maxSize > 0 && b.read > b.maxSize {
+		return n, fmt.Errorf("%w: more than %d bytes", ErrTooLarge, b.maxSize)
+	}
+
+	return n, err
+}
+
+// probe checks that the URL is an audio file (content type and size), and reads its metadata.
+func (h *Http) probe(ctx context.Context, url string) (Music, error) {
+	u, err := Url.ParseRequestURI(url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return Music{}, fmt.Errorf("%w: not a http(s) url (url: %s)", ErrUnsupported, util.RedactUrl(url))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, httpProbeTimeout)
+	defer cancel()
+
+	// 1. Check the content type and size with the headers
+	resp, err := h.head(ctx, url)
+	if err != nil {
+		return Music{}, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return Music{}, fmt.Errorf("%w: HTTP %d (url: %s)", ErrNotFound, resp.StatusCode, util.RedactUrl(url))
+	}
+
+	if resp.StatusCode >= 400 {
+		return Music{}, fmt.Errorf("%w: HTTP %d (url: %s)", ErrUnavailable, resp.StatusCode, util.RedactUrl(url))
+	}
+
+	if isIcyResponse(resp) {
+		return Music{}, errIcyStream
+	}
+
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !isAudioContentType(contentType, u.Path) {
+		return Music{}, fmt.Errorf("%w: content type is %q (url: %s)", ErrUnsupported, contentType, util.RedactUrl(url))
+	}
+
+	// the file of unknown size can be endless (e.g. chunked stream), so it cannot be checked against the limit
+	if h.MaxSize > 0 && resp.ContentLength <= 0 {
+		return Music{}, fmt.Errorf("%w: size is unknown (url: %s)", ErrUnsupported, util.RedactUrl(url))
+	}
+
+	if h.MaxSize > 0 && resp.ContentLength > h.MaxSize {
+		return Music{}, fmt.Errorf("%w: %d bytes (max: %d bytes)", ErrTooLarge, resp.ContentLength, h.MaxSize)
+	}
+
+	// 2. Read the metadata (it also checks that the media has an audio stream)
+	probe, err := ffprobe(ctx, url)
+	if err != nil {
+		if ctx.Err() != nil {
+			return Music{}, ctx.Err()
+		}
+		return Music{}, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+
+	// the title is read from the tags, or the file name of the URL
+	title := probe.Tags["title"]
+	if title == "" {
+		title, err = Url.PathUnescape(path.Base(u.Path))
+		if err != nil {
+			title = path.Base(u.Path)
+		}
+	}
+
+	if artist := probe.Tags["artist"]; artist != "" && probe.Tags["title"] != "" {
+		title = fmt.Sprintf("%s - %s", artist, title)
+	}
+
+	return Music{
+		Id:         MusicID("HTTP:" + util.GetSha256Hash(url)),
+		Title:      title,
+		RawUrl:     url,
+		Type:       "http",
+		Duration:   probe.Duration,
+		Uploader:   u.Host,
+		WebpageUrl: url,
+		Chapters:   probe.Chapters,
+	}, nil
+}
+
+// head requests the headers of the URL.
+// some servers do not allow HEAD, so it falls back to GET of the first byte.
+func (h *Http) head(ctx context.Context, url string) (*http.Response, error) {
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequestWithContext(ctx, method, url, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+		}
+
+		if method == http.MethodGet {
+			req.Header.Set("Range", "bytes=0-0")
+		}
+		req.Header.Set("Icy-MetaData", "1") // the radio stream answers with the icy-* headers
+
+		resp, err := h.Client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("%w: %v", ErrUnavailable, redactError(err))
+		}
+
+		if resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented {
+			resp.Body.Close()
+			continue
+		}
+
+		// the size of the partial content is in the Content-Range header (e.g. "bytes 0-0/12345")
+		if resp.StatusCode == http.StatusPartialContent {
+			if _, total, ok := strings.Cut(resp.Header.Get("Content-Range"), "/"); ok {
+				resp.ContentLength, _ = strconv.ParseInt(total, 10, 64)
+			}
+		}
+
+		return resp, nil
+	}
+
+	return nil, fmt.Errorf("%w: server does not allow HEAD or GET (url: %s)", ErrUnavailable, util.RedactUrl(url))
+}
+
+// isIcyResponse reports whether the response is from an internet radio stream. (it has the icy-* headers)
+func isIcyResponse(resp *http.Response) bool {
+	for k := range resp.Header {
+		if strings.HasPrefix(strings.ToLower(k), "icy-") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isAudioContentType reports whether the content type is an audio.
+// generic binary types are allowed only if the extension of the path is an audio.
+func isAudioContentType(contentType, urlPath string) bool {
+	switch {
+	case strings.HasPrefix(contentType, "audio/"):
+		return true
+	case contentType == "application/ogg", contentType == "video/ogg":
+		return true
+	case contentType == "", contentType == "application/octet-stream", contentType == "binary/octet-stream":
+		return httpExtensions[strings.ToLower(path.Ext(urlPath))]
+	}
+
+	return false
+}
@@ -0,0 +1,348 @@
+package Provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	Url "net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thirdscam/chatanium-musicbot/util"
+	"github.com/thirdscam/chatanium/src/Util/Log"
+)
+
+// The maximum time to wait for the server. (except streaming)
+const jellyfinTimeout = 15 * time.Second
+
+// The maximum number of songs of an album, artist or playlist.
+const jellyfinMaxSongs = 500
+
+// Jellyfin plays the music of the self-hosted Jellyfin server.
+//
+// Query:
+//   - "album:<name>" enqueues all songs of the album
+//   - "artist:<name>" enqueues all songs of the artist
+//   - "playlist:<name>" enqueues all songs of the playlist on the server
+//   - otherwise, searches the songs
+type Jellyfin struct {
+	BaseUrl string // e.g. https://jellyfin.example.com
+	ApiKey  string
+	UserId  string // the library of the user (optional, the whole server if empty)
+	Client  *http.Client
+
+	itemIds sync.Map // item ID of the songs (by MusicID)
+}
+
+// jellyfinItem is an item (song, album, artist or playlist) of the Jellyfin API.
+type jellyfinItem struct {
+	Id           string   `json:"Id"`
+	Name         string   `json:"Name"`
+	Album        string   `json:"Album"`
+	AlbumArtist  string   `json:"AlbumArtist"`
+	Artists      []string `json:"Artists"`
+	RunTimeTicks int64    `json:"RunTimeTicks"` // 100ns
+	ChildCount   int      `json:"ChildCount"`
+}
+
+func init() {
+	baseUrl := os.Getenv("MUSICBOT_JELLYFIN_URL")
+	if baseUrl == "" { // the server is not configured
+		return
+	}
+
+	Register(Entry{
+		Name:         "jellyfin",
+		Label:        "Jellyfin",
+		Capabilities: CapabilitySearch | CapabilityPlaylist,
+		Provider: &Jellyfin{
+			BaseUrl: strings.TrimSuffix(baseUrl, "/"),
+			ApiKey:  os.Getenv("MUSICBOT_JELLYFIN_API_KEY"),
+			UserId:  os.Getenv("MUSICBOT_JELLYFIN_USER_ID"),
+			Client:  &http.Client{Timeout: jellyfinTimeout},
+		},
+	})
+}
+
+func (j *Jellyfin) Start(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, jellyfinTimeout)
+	defer cancel()
+
+	var info struct {
+		ServerName string `json:"ServerName"`
+		Version    string `json:"Version"`
+	}
+	if err := j.call(ctx, "/System/Info", nil, &info); err != nil {
+		Log.Error.Printf("[MusicBot] Failed to connect to Jellyfin (%s): %v", j.BaseUrl, err)
+		return
+	}
+
+	Log.Info.Printf("[MusicBot] Jellyfin connected: %s (%s %s)", j.BaseUrl, info.ServerName, info.Version)
+}
+
+func (j *Jellyfin) GetMusic(ctx context.Context, query string) ([]Music, error) {
+	var songs []jellyfinItem
+	var err error
+
+	switch {
+	case strings.HasPrefix(query, "album:"):
+		songs, err = j.collection(ctx, "MusicAlbum", strings.TrimSpace(strings.TrimPrefix(query, "album:")))
+
+	case strings.HasPrefix(query, "artist:"):
+		songs, err = j.artist(ctx, strings.TrimSpace(strings.TrimPrefix(query, "artist:")))
+
+	case strings.HasPrefix(query, "playlist:"):
+		songs, err = j.collection(ctx, "Playlist", strings.TrimSpace(strings.TrimPrefix(query, "playlist:")))
+
+	default:
+		songs, err = j.search(ctx, query, 1)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(songs) == 0 {
+		return nil, fmt.Errorf("%w: no songs found on Jellyfin (query: %s)", ErrNotFound, query)
+	}
+
+	return j.toMusic(songs), nil
+}
+
+func (j *Jellyfin) Search(ctx context.Context, query string, limit int) ([]Music, error) {
+	songs, err := j.search(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(songs) == 0 {
+		return nil, fmt.Errorf("%w: no songs found on Jellyfin (query: %s)", ErrNotFound, query)
+	}
+
+	return j.toMusic(songs), nil
+}
+
+// Resolve returns the stream URL of the song with the API key.
+// (the API key is not kept in the music, because the music is shown in the messages)
+func (j *Jellyfin) Resolve(ctx context.Context, music Music) (Music, error) {
+	u, err := Url.Parse(music.RawUrl)
+	if err != nil {
+		return Music{}, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+
+	query := u.Query()
+	query.Set("api_key", j.ApiKey)
+	u.RawQuery = query.Encode()
+
+	music.RawUrl = u.String()
+	return music, nil
+}
+
+// Artwork returns the primary image of the song. (the server is usually not reachable from Discord)
+func (j *Jellyfin) Artwork(ctx context.Context, music Music) ([]byte, error) {
+	id, ok := j.itemIds.Load(music.Id)
+	if !ok {
+		return nil, fmt.Errorf("%w: no artwork", ErrNotFound)
+	}
+
+	req, err := j.request(ctx, "/Items/"+Url.PathEscape(id.(string))+"/Images/Primary", Url.Values{"maxHeight": {"300"}})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := j.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, redactError(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 || !strings.HasPrefix(resp.Header.Get("Content-Type"), "image/") {
+		return nil, fmt.Errorf("%w: no artwork (HTTP %d)", ErrNotFound, resp.StatusCode)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, 8*1024*1024))
+}
+
+// Playlists returns the playlists on the server. (suggested in the autocomplete of /play)
+func (j *Jellyfin) Playlists(ctx context.Context) ([]Playlist, error) {
+	items, err := j.items(ctx, Url.Values{"includeItemTypes": {"Playlist"}, "fields": {"ChildCount"}})
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Playlist{}
+	for _, v := range items {
+		result = append(result, Playlist{
+			Name:      v.Name,
+			Query:     "playlist:" + v.Name,
+			SongCount: v.ChildCount,
+		})
+	}
+
+	return result, nil
+}
+
+func (j *Jellyfin) search(ctx context.Context, query string, limit int) ([]jellyfinItem, error) {
+	return j.items(ctx, Url.Values{
+		"searchTerm":       {query},
+		"includeItemTypes": {"Audio"},
+		"limit":            {fmt.Sprint(limit)},
+	})
+}
+
+// collection returns the songs of the album or the playlist that matches the name.
+func (j *Jellyfin) collection(ctx context.Context, itemType, name string) ([]jellyfinItem, error) {
+	collections, err := j.items(ctx, Url.Values{"searchTerm": {name}, "includeItemTypes": {itemType}, "limit": {"10"}})
+	if err != nil {
+		return nil, err
+	}
+
+	id := findJellyfinItem(collections, name)
+	if id == "" {
+		return nil, fmt.Errorf("%w: no %s found on Jellyfin (name: %s)", ErrNotFound, strings.ToLower(itemType), name)
+	}
+
+	params := Url.Values{"parentId": {id}, "includeItemTypes": {"Audio"}, "limit": {fmt.Sprint(jellyfinMaxSongs)}}
+	if itemType == "MusicAlbum" { // the playlist keeps its own order
+		params.Set("sortBy", "ParentIndexNumber,IndexNumber,SortName")
+	}
+
+	return j.items(ctx, params)
+}
+
+// artist returns the songs of the artist that matches the name. (by album)
+func (j *Jellyfin) artist(ctx context.Context, name string) ([]jellyfinItem, error) {
+	var artists struct {
+		Items []jellyfinItem `json:"Items"`
+	}
+	if err := j.call(ctx, "/Artists", Url.Values{"searchTerm": {name}, "limit": {"10"}}, &artists); err != nil {
+		return nil, err
+	}
+
+	id := findJellyfinItem(artists.Items, name)
+	if id == "" {
+		return nil, fmt.Errorf("%w: no artist found on Jellyfin (name: %s)", ErrNotFound, name)
+	}
+
+	return j.items(ctx, Url.Values{
+		"artistIds":        {id},
+		"includeItemTypes": {"Audio"},
+		"sortBy":           {"Album,ParentIndexNumber,IndexNumber,SortName"},
+		"limit":            {fmt.Sprint(jellyfinMaxSongs)},
+	})
+}
+
+// findJellyfinItem returns the ID of the item with the exact name, or the first item. (empty if no items)
+func findJellyfinItem(items []jellyfinItem, name string) string {
+	for _, v := range items {
+		if strings.EqualFold(v.Name, name) {
+			return v.Id
+		}
+	}
+
+	if len(items) == 0 {
+		return ""
+	}
+
+	return items[0].Id
+}
+
+// items queries the items of the library. (recursively)
+func (j *Jellyfin) items(ctx context.Context, params Url.Values) ([]jellyfinItem, error) {
+	params.Set("recursive", "true")
+	if j.UserId != "" {
+		params.Set("userId", j.UserId)
+	}
+
+	var result struct {
+		Items []jellyfinItem `json:"Items"`
+	}
+	if err := j.call(ctx, "/Items", params, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Items, nil
+}
+
+// call calls the API and decodes the JSON response into v.
+func (j *Jellyfin) call(ctx context.Context, path string, params Url.Values, v any) error {
+	req, err := j.request(ctx, path, params)
+	if err != nil {
+		return err
+	}
+
+	resp, err := j.Client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: %v", ErrUnavailable, redactError(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: HTTP %d (path: %s)", ErrNotFound, resp.StatusCode, path)
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%w: HTTP %d (path: %s)", ErrUnavailable, resp.StatusCode, path)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("%w: invalid response: %v", ErrUnavailable, err)
+	}
+
+	return nil
+}
+
+// request builds the request of the API with the authentication. (the API key is sent in the header)
+func (j *Jellyfin) request(ctx context.Context, path string, params Url.Values) (*http.Request, error) {
+	url := j.BaseUrl + path
+	if len(params) > 0 {
+		url += "?" + params.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf(`MediaBrowser Client="chatanium-musicbot", Token="%s"`, j.ApiKey))
+
+	return req, nil
+}
+
+func (j *Jellyfin) toMusic(songs []jellyfinItem) []Music {
+	result := []Music{}
+	for _, v := range songs {
+		if v.Id == "" {
+			continue
+		}
+
+		title := v.Name
+		if v.Album != "" {
+			title = fmt.Sprintf("%s (%s)", v.Name, v.Album)
+		}
+
+		artist := v.AlbumArtist
+		if len(v.Artists) > 0 {
+			artist = strings.Join(v.Artists, ", ")
+		}
+
+		id := MusicID("JELLYFIN:" + util.GetSha256Hash(j.BaseUrl+":"+v.Id))
+		j.itemIds.Store(id, v.Id)
+
+		result = append(result, Music{
+			Id:       id,
+			Title:    title,
+			RawUrl:   fmt.Sprintf("%s/Audio/%s/stream?static=true", j.BaseUrl, Url.PathEscape(v.Id)), // without the API key (see Resolve)
+			Type:     "jellyfin",
+			Duration: time.Duration(v.RunTimeTicks) * 100,
+			Uploader: artist,
+		})
+	}
+
+	return result
+}
@@ -0,0 +1,396 @@
+package Provider
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thirdscam/chatanium-musicbot/util"
+	"github.com/thirdscam/chatanium/src/Util/Log"
+)
+
+// The audio file extensions that the local provider indexes.
+var localExtensions = map[string]bool{
+	".mp3":  true,
+	".flac": true,
+	".ogg":  true,
+	".opus": true,
+	".m4a":  true,
+	".wav":  true,
+}
+
+// The interval to scan the directory for changes.
+const localScanInterval = 30 * time.Second
+
+// The maximum time to read the tags of a file.
+const localProbeTimeout = 10 * time.Second
+
+// Local plays the audio files in the directory. (MUSICBOT_LOCAL_PATH)
+//
+// Query:
+//   - "album:<name>" enqueues all tracks of the album
+//   - "folder:<path>" enqueues all tracks in the folder (relative to the directory)
+//   - otherwise, searches the title, artist and album of the tracks
+type Local struct {
+	Root string
+
+	mu     sync.RWMutex
+	tracks map[string]localTrack // by absolute path of the file
+}
+
+// localTrack is an indexed audio file.
+type localTrack struct {
+	Path    string
+	ModTime time.Time
+	Size    int64
+
+	Title      string
+	Artist     string
+	Album      string
+	Disc       int
+	Track      int
+	Duration   time.Duration
+	HasArtwork bool
+}
+
+func init() {
+	root := os.Getenv("MUSICBOT_LOCAL_PATH")
+	if root == "" { // the local library is not configured
+		return
+	}
+
+	// the path of the track is used as the media URL, so it must not depend on the working directory
+	root, err := filepath.Abs(root)
+	if err != nil {
+		Log.Error.Printf("[MusicBot] Invalid local library path: %v", err)
+		return
+	}
+
+	Register(Entry{
+		Name:         "local",
+		Label:        "Local library",
+		Capabilities: CapabilitySearch | CapabilityPlaylist,
+		Provider:     &Local{Root: root},
+	})
+}
+
+func (l *Local) Start(ctx context.Context) {
+	Log.Info.Printf("[MusicBot] Local library: %s", l.Root)
+
+	// the first scan finishes before the bot starts, so the queries right after the start find the tracks
+	l.scan(ctx)
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(localScanInterval):
+			}
+
+			// scan the directory for changes (until the provider is stopped)
+			l.scan(ctx)
+		}
+	}()
+}
+
+func (l *Local) GetMusic(ctx context.Context, query string) ([]Music, error) {
+	var tracks []localTrack
+
+	switch {
+	case strings.HasPrefix(query, "album:"):
+		tracks = l.album(strings.TrimSpace(strings.TrimPrefix(query, "album:")))
+
+	case strings.HasPrefix(query, "folder:"):
+		tracks = l.folder(strings.TrimSpace(strings.TrimPrefix(query, "folder:")))
+
+	default:
+		tracks = l.search(query)
+		if len(tracks) > 1 {
+			tracks = tracks[:1]
+		}
+	}
+
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("%w: no tracks found in the local library (query: %s)", ErrNotFound, query)
+	}
+
+	result := []Music{}
+	for _, v := range tracks {
+		result = append(result, v.toMusic())
+	}
+
+	return result, nil
+}
+
+func (l *Local) Search(ctx context.Context, query string, limit int) ([]Music, error) {
+	tracks := l.search(query)
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("%w: no tracks found in the local library (query: %s)", ErrNotFound, query)
+	}
+
+	if len(tracks) > limit {
+		tracks = tracks[:limit]
+	}
+
+	result := []Music{}
+	for _, v := range tracks {
+		result = append(result, v.toMusic())
+	}
+
+	return result, nil
+}
+
+func (l *Local) Resolve(ctx context.Context, music Music) (Music, error) {
+	// the file can be removed after the query
+	if _, err := os.Stat(music.RawUrl); err != nil {
+		return Music{}, fmt.Errorf("%w: %v", ErrNotFound, err)
+	}
+
+	return music, nil
+}
+
+// Artwork returns the cover art embedded in the file.
+func (l *Local) Artwork(ctx context.Context, music Music) ([]byte, error) {
+	l.mu.RLock()
+	track, ok := l.tracks[music.RawUrl]
+	l.mu.RUnlock()
+
+	if !ok || !track.HasArtwork {
+		return nil, fmt.Errorf("%w: no artwork", ErrNotFound)
+	}
+
+	return ffmpegArtwork(ctx, track.Path)
+}
+
+// scan indexes the audio files in the directory.
+// the tags are read again only if the file is changed.
+func (l *Local) scan(ctx context.Context) {
+	l.mu.RLock()
+	before := l.tracks
+	l.mu.RUnlock()
+
+	tracks := map[string]localTrack{}
+	updated := 0
+
+	err := filepath.WalkDir(l.Root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			Log.Verbose.Printf("[MusicBot] Failed to scan local library: %v", err)
+			return nil // skip the unreadable entry
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if d.IsDir() || !localExtensions[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		// reuse the index if the file is not changed
+		if v, ok := before[path]; ok && v.ModTime.Equal(info.ModTime()) && v.Size == info.Size() {
+			tracks[path] = v
+			return nil
+		}
+
+		track, err := probeLocalTrack(ctx, path, info)
+		if err != nil {
+			Log.Verbose.Printf("[MusicBot] Failed to read tags (%s): %v", path, err)
+		}
+
+		tracks[path] = track
+		updated++
+		return nil
+	})
+	if err != nil {
+		Log.Warn.Printf("[MusicBot] Failed to scan local library: %v", err)
+		return
+	}
+
+	l.mu.Lock()
+	l.tracks = tracks
+	l.mu.Unlock()
+
+	removed := 0
+	for k := range before {
+		if _, ok := tracks[k]; !ok {
+			removed++
+		}
+	}
+
+	if updated > 0 || removed > 0 {
+		Log.Info.Printf("[MusicBot] Local library indexed: %d tracks (%d updated, %d removed)", len(tracks), updated, removed)
+	}
+}
+
+// probeLocalTrack reads the tags of the file.
+// if the tags cannot be read, the track is still playable with the file name as title.
+func probeLocalTrack(ctx context.Context, path string, info fs.FileInfo) (localTrack, error) {
+	track := localTrack{
+		Path:    path,
+		ModTime: info.ModTime(),
+		Size:    info.Size(),
+		Title:   strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, localProbeTimeout)
+	defer cancel()
+
+	probe, err := ffprobe(ctx, path)
+	if err != nil {
+		return track, err
+	}
+
+	if v := probe.Tags["title"]; v != "" {
+		track.Title = v
+	}
+
+	track.Artist = probe.Tags["artist"]
+	if track.Artist == "" {
+		track.Artist = probe.Tags["album_artist"]
+	}
+
+	track.Album = probe.Tags["album"]
+	track.Disc = parseTrackNumber(probe.Tags["disc"])
+	track.Track = parseTrackNumber(probe.Tags["track"])
+	track.Duration = probe.Duration
+	track.HasArtwork = probe.HasArtwork
+
+	return track, nil
+}
+
+// search returns the tracks that contain every word of the query
+// in the title, artist, album or file path. (ordered by relevance)
+func (l *Local) search(query string) []localTrack {
+	words := strings.Fields(strings.ToLower(query))
+	if len(words) == 0 {
+		return nil
+	}
+
+	type scored struct {
+		track localTrack
+		score int
+	}
+	candidates := []scored{}
+
+	l.mu.RLock()
+	for _, v := range l.tracks {
+		title, artist, album := strings.ToLower(v.Title), strings.ToLower(v.Artist), strings.ToLower(v.Album)
+		path := strings.ToLower(v.Path)
+
+		score := 0
+		for _, word := range words {
+			switch {
+			case strings.Contains(title, word):
+				score += 3
+			case strings.Contains(artist, word):
+				score += 2
+			case strings.Contains(album, word):
+				score += 2
+			case strings.Contains(path, word):
+				score += 1
+			default: // every word must match
+				score = -1
+			}
+
+			if score < 0 {
+				break
+			}
+		}
+
+		if score > 0 {
+			candidates = append(candidates, scored{v, score})
+		}
+	}
+	l.mu.RUnlock()
+
+	sort.Slice(candidates, func(a, b int) bool {
+		if candidates[a].score != candidates[b].score {
+			return candidates[a].score > candidates[b].score
+		}
+		return candidates[a].track.Title < candidates[b].track.Title
+	})
+
+	result := []localTrack{}
+	for _, v := range candidates {
+		result = append(result, v.track)
+	}
+
+	return result
+}
+
+// album returns the tracks of the album. (ordered by disc and track number)
+func (l *Local) album(name string) []localTrack {
+	result := []localTrack{}
+
+	l.mu.RLock()
+	for _, v := range l.tracks {
+		if v.Album != "" && strings.EqualFold(v.Album, name) {
+			result = append(result, v)
+		}
+	}
+	l.mu.RUnlock()
+
+	sortTracks(result)
+	return result
+}
+
+// folder returns the tracks in the folder and its subfolders. (ordered by disc and track number)
+func (l *Local) folder(name string) []localTrack {
+	// the folder must be inside of the root directory
+	dir := filepath.Join(l.Root, filepath.Clean("/"+name))
+	result := []localTrack{}
+
+	l.mu.RLock()
+	for _, v := range l.tracks {
+		rel, err := filepath.Rel(dir, v.Path)
+		if err == nil && !strings.HasPrefix(rel, "..") {
+			result = append(result, v)
+		}
+	}
+	l.mu.RUnlock()
+
+	sortTracks(result)
+	return result
+}
+
+// sortTracks sorts the tracks by folder, disc and track number, then file name.
+func sortTracks(tracks []localTrack) {
+	sort.Slice(tracks, func(a, b int) bool {
+		x, y := tracks[a], tracks[b]
+		if filepath.Dir(x.Path) != filepath.Dir(y.Path) {
+			return filepath.Dir(x.Path) < filepath.Dir(y.Path)
+		}
+		if x.Disc != y.Disc {
+			return x.Disc < y.Disc
+		}
+		if x.Track != y.Track {
+			return x.Track < y.Track
+		}
+		return x.Path < y.Path
+	})
+}
+
+func (t localTrack) toMusic() Music {
+	return Music{
+		// the file can be replaced with the same name, so the modified time is a part of the key.
+		Id:       MusicID("LOCAL:" + util.GetSha256Hash(t.Path+":"+strconv.FormatInt(t.ModTime.UnixNano(), 10))),
+		Title:    t.Title,
+		RawUrl:   t.Path,
+		Type:     "local",
+		Duration: t.Duration,
+		Uploader: t.Artist,
+	}
+}
@@ -0,0 +1,34 @@
+package Provider
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalFirstScan(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "Album"), 0o755)
+	os.WriteFile(filepath.Join(root, "Album", "First Song.mp3"), []byte("not an audio"), 0o644) // the title is the file name
+	os.WriteFile(filepath.Join(root, "notes.txt"), []byte("not an audio"), 0o644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l := &Local{Root: root}
+	l.Start(ctx)
+
+	// the library is indexed as soon as the provider is started
+	m, err := l.GetMusic(ctx, "first song")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 1 || m[0].RawUrl != filepath.Join(root, "Album", "First Song.mp3") {
+		t.Fatalf("unexpected result: %+v", m)
+	}
+
+	if _, err := l.GetMusic(ctx, "notes"); err == nil {
+		t.Fatal("the file that is not an audio is indexed")
+	}
+}
@@ -0,0 +1,259 @@
+package Provider
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	Url "net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thirdscam/chatanium-musicbot/util"
+)
+
+// newSubsonicStub starts a stub Subsonic server with an album, an artist and a playlist.
+// it rejects the requests whose token does not match the password.
+func newSubsonicStub(t *testing.T, user, password string) *httptest.Server {
+	songs := []map[string]any{
+		{"id": "1", "title": "First Song", "artist": "Artist", "album": "Album", "duration": 180, "coverArt": "al-1"},
+		{"id": "2", "title": "Second Song", "artist": "Artist", "album": "Album", "duration": 200, "coverArt": "al-1"},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		token := md5.Sum([]byte(password + q.Get("s")))
+
+		body := map[string]any{"status": "ok"}
+		switch {
+		case q.Get("u") != user || q.Get("t") != hex.EncodeToString(token[:]):
+			body = map[string]any{"status": "failed", "error": map[string]any{"code": 40, "message": "Wrong username or password"}}
+
+		case r.URL.Path == "/rest/search3.view" && q.Get("albumCount") != "0":
+			body["searchResult3"] = map[string]any{"album": []map[string]any{
+				{"id": "al-2", "name": "Album (Live)", "artist": "Artist"},
+				{"id": "al-1", "name": "Album", "artist": "Artist"},
+			}}
+
+		case r.URL.Path == "/rest/search3.view" && q.Get("artistCount") != "0":
+			body["searchResult3"] = map[string]any{"artist": []map[string]any{{"id": "ar-1", "name": "Artist"}}}
+
+		case r.URL.Path == "/rest/search3.view":
+			body["searchResult3"] = map[string]any{"song": songs[:1]}
+
+		case r.URL.Path == "/rest/getArtist.view" && q.Get("id") == "ar-1":
+			body["artist"] = map[string]any{"album": []map[string]any{{"id": "al-1", "name": "Album"}}}
+
+		case r.URL.Path == "/rest/getAlbum.view" && q.Get("id") == "al-1":
+			body["album"] = map[string]any{"song": songs}
+
+		case r.URL.Path == "/rest/getPlaylists.view":
+			body["playlists"] = map[string]any{"playlist": []map[string]any{
+				{"id": "pl-1", "name": "Favorites", "songCount": 2},
+			}}
+
+		case r.URL.Path == "/rest/getPlaylist.view" && q.Get("id") == "pl-1":
+			body["playlist"] = map[string]any{"entry": songs}
+
+		default:
+			body = map[string]any{"status": "failed", "error": map[string]any{"code": 70, "message": "Not found"}}
+		}
+
+		json.NewEncoder(w).Encode(map[string]any{"subsonic-response": body})
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// newJellyfinStub starts a stub Jellyfin server with an album, an artist and a playlist.
+// it rejects the requests without the API key.
+func newJellyfinStub(t *testing.T, apiKey string) *httptest.Server {
+	songs := []map[string]any{
+		{"Id": "s1", "Name": "First Song", "Album": "Album", "Artists": []string{"Artist"}, "RunTimeTicks": 1800000000},
+		{"Id": "s2", "Name": "Second Song", "Album": "Album", "AlbumArtist": "Artist", "RunTimeTicks": 2000000000},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Authorization"), `Token="`+apiKey+`"`) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		q := r.URL.Query()
+		var body any
+		switch {
+		case r.URL.Path == "/Artists":
+			body = map[string]any{"Items": []map[string]any{{"Id": "ar1", "Name": "Artist"}}}
+
+		case r.URL.Path != "/Items":
+			w.WriteHeader(http.StatusNotFound)
+			return
+
+		case q.Get("includeItemTypes") == "MusicAlbum":
+			body = map[string]any{"Items": []map[string]any{{"Id": "al2", "Name": "Album (Live)"}, {"Id": "al1", "Name": "Album"}}}
+
+		case q.Get("includeItemTypes") == "Playlist" && q.Get("searchTerm") != "unknown":
+			body = map[string]any{"Items": []map[string]any{{"Id": "pl1", "Name": "Favorites", "ChildCount": 2}}}
+
+		case q.Get("includeItemTypes") == "Playlist":
+			body = map[string]any{"Items": []map[string]any{}}
+
+		case q.Get("parentId") == "al1", q.Get("parentId") == "pl1", q.Get("artistIds") == "ar1":
+			body = map[string]any{"Items": songs}
+
+		case q.Get("searchTerm") != "":
+			body = map[string]any{"Items": songs[:1]}
+
+		default:
+			body = map[string]any{"Items": []map[string]any{}}
+		}
+
+		json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// mediaServer is the provider of a media server. (Subsonic, Jellyfin)
+type mediaServer interface {
+	Interface
+	PlaylistLister
+}
+
+// Both media servers have the same library, so the providers must give the same results.
+var mediaServerTests = []struct {
+	name string
+
+	// newProvider starts the stub server, and returns the provider of it. (with the wrong credential if !isAuthorized)
+	newProvider func(t *testing.T, isAuthorized bool) mediaServer
+
+	streamPath string // the path of the stream URL of the first song
+	credential string // the query parameter of the stream URL that has the credential
+}{
+	{
+		name: "subsonic",
+		newProvider: func(t *testing.T, isAuthorized bool) mediaServer {
+			server := newSubsonicStub(t, "user", "secret")
+			password := "secret"
+			if !isAuthorized {
+				password = "wrong"
+			}
+			return &Subsonic{BaseUrl: server.URL, User: "user", Password: password, Client: http.DefaultClient}
+		},
+		streamPath: "/rest/stream.view",
+		credential: "t",
+	},
+	{
+		name: "jellyfin",
+		newProvider: func(t *testing.T, isAuthorized bool) mediaServer {
+			server := newJellyfinStub(t, "key")
+			apiKey := "key"
+			if !isAuthorized {
+				apiKey = "wrong"
+			}
+			return &Jellyfin{BaseUrl: server.URL, ApiKey: apiKey, Client: http.DefaultClient}
+		},
+		streamPath: "/Audio/s1/stream",
+		credential: "api_key",
+	},
+}
+
+func TestMediaServer(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tt := range mediaServerTests {
+		t.Run(tt.name+"/auth", func(t *testing.T) {
+			if _, err := tt.newProvider(t, true).Playlists(ctx); err != nil {
+				t.Fatalf("request with the right credential: %v", err)
+			}
+
+			_, err := tt.newProvider(t, false).Playlists(ctx)
+			if !errors.Is(err, ErrUnavailable) {
+				t.Fatalf("request with a wrong credential: got %v, want ErrUnavailable", err)
+			}
+		})
+
+		t.Run(tt.name+"/search", func(t *testing.T) {
+			p := tt.newProvider(t, true)
+
+			m, err := p.GetMusic(ctx, "first")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(m) != 1 || m[0].Title != "First Song (Album)" || m[0].Uploader != "Artist" || m[0].Duration != 180*time.Second {
+				t.Fatalf("unexpected result: %+v", m)
+			}
+
+			// the stored URL has no credential, and the resolved one has it
+			u, _ := Url.Parse(m[0].RawUrl)
+			if u.Query().Has(tt.credential) {
+				t.Errorf("the URL of the query has the credential: %s", m[0].RawUrl)
+			}
+
+			resolved, err := p.Resolve(ctx, m[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			u, _ = Url.Parse(resolved.RawUrl)
+			credential := u.Query().Get(tt.credential)
+			if u.Path != tt.streamPath || credential == "" {
+				t.Fatalf("unexpected stream URL: %s", resolved.RawUrl)
+			}
+
+			// the stream URL is given to ffmpeg, so its messages must be redacted before logging
+			message := "[http @ 0x1] HTTP error 500 Internal Server Error: " + resolved.RawUrl
+			if redacted := util.RedactText(message); strings.Contains(redacted, credential) {
+				t.Errorf("the credential is not redacted: %s", redacted)
+			}
+		})
+
+		// the album with the exact name is preferred
+		for _, query := range []string{"album:album", "artist:artist"} {
+			t.Run(tt.name+"/"+query, func(t *testing.T) {
+				m, err := tt.newProvider(t, true).GetMusic(ctx, query)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if len(m) != 2 || !strings.HasPrefix(m[0].Title, "First Song") || !strings.HasPrefix(m[1].Title, "Second Song") || m[1].Uploader != "Artist" {
+					t.Fatalf("unexpected result: %+v", m)
+				}
+			})
+		}
+
+		t.Run(tt.name+"/playlist", func(t *testing.T) {
+			p := tt.newProvider(t, true)
+
+			playlists, err := p.Playlists(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(playlists) != 1 || playlists[0].Query != "playlist:Favorites" || playlists[0].SongCount != 2 {
+				t.Fatalf("unexpected playlists: %+v", playlists)
+			}
+
+			m, err := p.GetMusic(ctx, playlists[0].Query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(m) != 2 {
+				t.Fatalf("unexpected result: %+v", m)
+			}
+
+			_, err = p.GetMusic(ctx, "playlist:unknown")
+			if !errors.Is(err, ErrNotFound) {
+				t.Fatalf("unknown playlist: got %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
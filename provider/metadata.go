@@ -0,0 +1,637 @@
+package Provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	Url "net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thirdscam/chatanium/src/Util/Log"
+)
+
+// The maximum number of tracks to read from an album or playlist of the streaming services.
+const metadataMaxTracks = 200
+
+// The maximum size of the web page to read the metadata from. (in bytes)
+const metadataMaxPageSize = 10 << 20
+
+// The maximum number of tracks in a request of the Spotify Web API. (/v1/tracks?ids=)
+const spotifyBatchSize = 50
+
+// TrackMeta is the metadata of a track on a streaming service that cannot be played directly. (e.g. Spotify)
+type TrackMeta struct {
+	Title    string
+	Artist   string
+	Album    string
+	ISRC     string
+	Duration time.Duration // zero if unknown
+}
+
+// MetadataResolver extracts the tracks from the links of a streaming service.
+//
+// It is used by the Streaming provider, and can be replaced with a fixture in tests.
+type MetadataResolver interface {
+	// Match reports whether the resolver handles the URL.
+	Match(url string) bool
+
+	// Resolve returns the tracks of the URL. (track, album or playlist)
+	Resolve(ctx context.Context, url string) ([]TrackMeta, error)
+}
+
+// request sends the request, and converts the failure of the request into the errors of the providers.
+func request(ctx context.Context, client *http.Client, req *http.Request) (*http.Response, error) {
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		err = fmt.Errorf("%w: HTTP %d (url: %s)", ErrNotFound, resp.StatusCode, req.URL.Redacted())
+	case resp.StatusCode == http.StatusTooManyRequests:
+		err = fmt.Errorf("%w: HTTP %d (url: %s)", ErrRateLimited, resp.StatusCode, req.URL.Redacted())
+	case resp.StatusCode >= 400:
+		err = fmt.Errorf("%w: HTTP %d (url: %s)", ErrUnavailable, resp.StatusCode, req.URL.Redacted())
+	}
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// getJson requests the URL and decodes the JSON response into v.
+func getJson(ctx context.Context, client *http.Client, req *http.Request, v any) error {
+	resp, err := request(ctx, client, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("%w: invalid response: %v", ErrUnavailable, err)
+	}
+
+	return nil
+}
+
+// DeezerResolver resolves the links of Deezer with its public API.
+type DeezerResolver struct {
+	Client *http.Client
+}
+
+// e.g. https://www.deezer.com/en/track/3135556
+var deezerPath = regexp.MustCompile(`^/(?:[a-z]{2}(?:-[a-z]{2})?/)?(track|album|playlist)/(\d+)`)
+
+func (d *DeezerResolver) Match(url string) bool {
+	u, err := Url.ParseRequestURI(url)
+	if err != nil {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	return host == "deezer.page.link" || host == "link.deezer.com" || (matchHost(host, []string{"deezer.com"}) && deezerPath.MatchString(u.Path))
+}
+
+type deezerTrack struct {
+	Title    string `json:"title"`
+	ISRC     string `json:"isrc"`
+	Duration int    `json:"duration"`
+	Artist   struct {
+		Name string `json:"name"`
+	} `json:"artist"`
+	Album struct {
+		Title string `json:"title"`
+	} `json:"album"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (d *DeezerResolver) Resolve(ctx context.Context, url string) ([]TrackMeta, error) {
+	// the short link redirects to the page of the track
+	u, err := followRedirect(ctx, d.Client, url)
+	if err != nil {
+		return nil, err
+	}
+
+	match := deezerPath.FindStringSubmatch(u.Path)
+	if match == nil {
+		return nil, fmt.Errorf("%w: not a link of track, album or playlist (url: %s)", ErrUnsupported, url)
+	}
+
+	kind, id := match[1], match[2]
+	if kind == "track" {
+		var track deezerTrack
+		if err := d.get(ctx, fmt.Sprintf("https://api.deezer.com/track/%s", id), &track); err != nil {
+			return nil, err
+		}
+		if track.Error != nil {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, track.Error.Message)
+		}
+		return []TrackMeta{track.toMeta()}, nil
+	}
+
+	// album or playlist (paginated)
+	result := []TrackMeta{}
+	next := fmt.Sprintf("https://api.deezer.com/%s/%s/tracks?limit=100", kind, id)
+	for next != "" && len(result) < metadataMaxTracks {
+		var page struct {
+			Data  []deezerTrack `json:"data"`
+			Next  string        `json:"next"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := d.get(ctx, next, &page); err != nil {
+			return nil, err
+		}
+		if page.Error != nil {
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, page.Error.Message)
+		}
+
+		for _, v := range page.Data {
+			result = append(result, v.toMeta())
+		}
+		next = page.Next
+	}
+
+	return capTracks(result), nil
+}
+
+func (d *DeezerResolver) get(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	return getJson(ctx, d.Client, req, v)
+}
+
+func (t deezerTrack) toMeta() TrackMeta {
+	return TrackMeta{
+		Title:    t.Title,
+		Artist:   t.Artist.Name,
+		Album:    t.Album.Title,
+		ISRC:     t.ISRC,
+		Duration: time.Duration(t.Duration) * time.Second,
+	}
+}
+
+// AppleMusicResolver resolves the links of Apple Music with the iTunes lookup API.
+//
+// The API of the playlists needs the developer token, so the playlists are read from the structured data of their pages.
+type AppleMusicResolver struct {
+	Client *http.Client
+}
+
+// e.g. https://music.apple.com/us/album/name/1440857781?i=1440858100
+var appleMusicPath = regexp.MustCompile(`^/([a-z]{2})/(album|song|playlist)/(?:[^/]+/)?([\w.-]+)`)
+
+func (a *AppleMusicResolver) Match(url string) bool {
+	u, err := Url.ParseRequestURI(url)
+	if err != nil {
+		return false
+	}
+
+	return strings.ToLower(u.Hostname()) == "music.apple.com" && appleMusicPath.MatchString(u.Path)
+}
+
+func (a *AppleMusicResolver) Resolve(ctx context.Context, url string) ([]TrackMeta, error) {
+	u, err := Url.ParseRequestURI(url)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+
+	match := appleMusicPath.FindStringSubmatch(u.Path)
+	if match == nil {
+		return nil, fmt.Errorf("%w: not a link of song, album or playlist (url: %s)", ErrUnsupported, url)
+	}
+
+	country, kind, id := match[1], match[2], match[3]
+	if kind == "playlist" {
+		return a.resolvePlaylist(ctx, url)
+	}
+
+	// the song in the album is given by the "i" parameter
+	params := Url.Values{"country": {country}}
+	if songId := u.Query().Get("i"); songId != "" {
+		params.Set("id", songId)
+	} else {
+		params.Set("id", id)
+		if kind == "album" {
+			params.Set("entity", "song")
+			params.Set("limit", fmt.Sprint(metadataMaxTracks))
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "https://itunes.apple.com/lookup?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var data struct {
+		Results []struct {
+			WrapperType    string `json:"wrapperType"`
+			Kind           string `json:"kind"`
+			TrackName      string `json:"trackName"`
+			ArtistName     string `json:"artistName"`
+			CollectionName string `json:"collectionName"`
+			TrackTimeMs    int    `json:"trackTimeMillis"`
+		} `json:"results"`
+	}
+	if err := getJson(ctx, a.Client, req, &data); err != nil {
+		return nil, err
+	}
+
+	result := []TrackMeta{}
+	for _, v := range data.Results {
+		if v.WrapperType != "track" || v.Kind != "song" { // the album itself is also in the results
+			continue
+		}
+
+		result = append(result, TrackMeta{
+			Title:    v.TrackName,
+			Artist:   v.ArtistName,
+			Album:    v.CollectionName,
+			Duration: time.Duration(v.TrackTimeMs) * time.Millisecond,
+		})
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("%w: no songs found (url: %s)", ErrNotFound, url)
+	}
+
+	return capTracks(result), nil
+}
+
+// e.g. <script id="schema:music-playlist" type="application/ld+json">{...}</script>
+var jsonLdScript = regexp.MustCompile(`(?s)<script[^>]*type="?application/ld\+json"?[^>]*>(.*?)</script>`)
+
+// e.g. PT3M20S (ISO 8601)
+var isoDuration = regexp.MustCompile(`^PT(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?$`)
+
+// appleMusicPlaylist is the structured data of the playlist page. (schema.org MusicPlaylist)
+type appleMusicPlaylist struct {
+	Type  string `json:"@type"`
+	Track []struct {
+		Name     string          `json:"name"`
+		Duration string          `json:"duration"`
+		ByArtist json.RawMessage `json:"byArtist"` // an artist or the list of the artists
+		InAlbum  struct {
+			Name string `json:"name"`
+		} `json:"inAlbum"`
+	} `json:"track"`
+}
+
+// resolvePlaylist reads the tracks of the playlist from its page.
+func (a *AppleMusicResolver) resolvePlaylist(ctx context.Context, url string) ([]TrackMeta, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := request(ctx, a.Client, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	page, err := io.ReadAll(io.LimitReader(resp.Body, metadataMaxPageSize))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	for _, match := range jsonLdScript.FindAllSubmatch(page, -1) {
+		var playlist appleMusicPlaylist
+		if json.Unmarshal(match[1], &playlist) != nil || playlist.Type != "MusicPlaylist" {
+			continue
+		}
+
+		result := []TrackMeta{}
+		for _, v := range playlist.Track {
+			result = append(result, TrackMeta{
+				Title:    v.Name,
+				Artist:   jsonLdArtist(v.ByArtist),
+				Album:    v.InAlbum.Name,
+				Duration: parseIsoDuration(v.Duration),
+			})
+		}
+
+		if len(result) == 0 {
+			return nil, fmt.Errorf("%w: no tracks in the playlist (url: %s)", ErrNotFound, url)
+		}
+
+		return capTracks(result), nil
+	}
+
+	return nil, fmt.Errorf("%w: no playlist data in the page (url: %s)", ErrUnavailable, url)
+}
+
+// jsonLdArtist returns the names of the artists of the structured data. (joined with ", ")
+func jsonLdArtist(data json.RawMessage) string {
+	type artist struct {
+		Name string `json:"name"`
+	}
+
+	var artists []artist
+	if json.Unmarshal(data, &artists) != nil {
+		var v artist
+		if json.Unmarshal(data, &v) != nil {
+			return ""
+		}
+		artists = []artist{v}
+	}
+
+	names := []string{}
+	for _, v := range artists {
+		if v.Name != "" {
+			names = append(names, v.Name)
+		}
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// parseIsoDuration parses the duration of ISO 8601. (zero if invalid)
+func parseIsoDuration(s string) time.Duration {
+	match := isoDuration.FindStringSubmatch(s)
+	if match == nil {
+		return 0
+	}
+
+	hours, _ := strconv.Atoi(match[1])
+	minutes, _ := strconv.Atoi(match[2])
+	seconds, _ := strconv.ParseFloat(match[3], 64)
+
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds*float64(time.Second))
+}
+
+// SpotifyResolver resolves the links of Spotify with the Web API. (client credentials flow)
+//
+// The links are rejected with ErrNotConfigured if the client credentials are not set.
+type SpotifyResolver struct {
+	ClientId     string
+	ClientSecret string
+	Client       *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// e.g. https://open.spotify.com/intl-ja/track/4cOdK2wGLETKBW3PvgPWqT
+var spotifyPath = regexp.MustCompile(`^/(?:intl-[\w-]+/)?(track|album|playlist)/(\w+)`)
+
+func (s *SpotifyResolver) Match(url string) bool {
+	u, err := Url.ParseRequestURI(url)
+	if err != nil {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	return host == "spotify.link" || (host == "open.spotify.com" && spotifyPath.MatchString(u.Path))
+}
+
+type spotifyTrack struct {
+	Id         string `json:"id"`
+	Name       string `json:"name"`
+	DurationMs int    `json:"duration_ms"`
+	Artists    []struct {
+		Name string `json:"name"`
+	} `json:"artists"`
+	Album struct {
+		Name string `json:"name"`
+	} `json:"album"`
+	ExternalIds struct {
+		ISRC string `json:"isrc"`
+	} `json:"external_ids"`
+}
+
+func (s *SpotifyResolver) Resolve(ctx context.Context, url string) ([]TrackMeta, error) {
+	if s.ClientId == "" || s.ClientSecret == "" {
+		return nil, fmt.Errorf("%w: Spotify links need MUSICBOT_SPOTIFY_CLIENT_ID and MUSICBOT_SPOTIFY_CLIENT_SECRET (url: %s)", ErrNotConfigured, url)
+	}
+
+	// the short link redirects to the page of the track
+	u, err := followRedirect(ctx, s.Client, url)
+	if err != nil {
+		return nil, err
+	}
+
+	match := spotifyPath.FindStringSubmatch(u.Path)
+	if match == nil {
+		return nil, fmt.Errorf("%w: not a link of track, album or playlist (url: %s)", ErrUnsupported, url)
+	}
+
+	kind, id := match[1], match[2]
+	if kind == "track" {
+		var track spotifyTrack
+		if err := s.get(ctx, "https://api.spotify.com/v1/tracks/"+id, &track); err != nil {
+			return nil, err
+		}
+		return []TrackMeta{track.toMeta("")}, nil
+	}
+
+	// the album has the name of itself, and its tracks have no album
+	album := ""
+	if kind == "album" {
+		var data struct {
+			Name string `json:"name"`
+		}
+		if err := s.get(ctx, "https://api.spotify.com/v1/albums/"+id, &data); err != nil {
+			return nil, err
+		}
+		album = data.Name
+	}
+
+	// album or playlist tracks (paginated)
+	tracks := []spotifyTrack{}
+	next := fmt.Sprintf("https://api.spotify.com/v1/%ss/%s/tracks?limit=50", kind, id)
+	for next != "" && len(tracks) < metadataMaxTracks {
+		var page struct {
+			Items []json.RawMessage `json:"items"`
+			Next  string            `json:"next"`
+		}
+		if err := s.get(ctx, next, &page); err != nil {
+			return nil, err
+		}
+
+		for _, v := range page.Items {
+			// the item of the playlist wraps the track
+			var item struct {
+				Track *spotifyTrack `json:"track"`
+			}
+			var track spotifyTrack
+			if kind == "playlist" {
+				if json.Unmarshal(v, &item) != nil || item.Track == nil { // removed track
+					continue
+				}
+				track = *item.Track
+			} else if json.Unmarshal(v, &track) != nil {
+				continue
+			}
+
+			tracks = append(tracks, track)
+		}
+		next = page.Next
+	}
+
+	if len(tracks) > metadataMaxTracks {
+		tracks = tracks[:metadataMaxTracks]
+	}
+
+	// the tracks of the album are simplified (without the ISRC), so they are matched by the title if this fails
+	if kind == "album" {
+		if err := s.fillIsrc(ctx, tracks); err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			Log.Verbose.Printf("[MusicBot] Failed to get ISRCs of the album tracks: %v", err)
+		}
+	}
+
+	result := []TrackMeta{}
+	for _, v := range tracks {
+		result = append(result, v.toMeta(album))
+	}
+
+	return result, nil
+}
+
+// fillIsrc fills in the ISRCs of the simplified tracks. (spotifyBatchSize tracks per request)
+func (s *SpotifyResolver) fillIsrc(ctx context.Context, tracks []spotifyTrack) error {
+	for start := 0; start < len(tracks); start += spotifyBatchSize {
+		batch := tracks[start:min(start+spotifyBatchSize, len(tracks))]
+
+		ids := []string{}
+		for _, v := range batch {
+			ids = append(ids, v.Id)
+		}
+
+		var data struct {
+			Tracks []*spotifyTrack `json:"tracks"` // in the order of the ids (null if not found)
+		}
+		if err := s.get(ctx, "https://api.spotify.com/v1/tracks?ids="+strings.Join(ids, ","), &data); err != nil {
+			return err
+		}
+
+		for j, v := range data.Tracks {
+			if v != nil && j < len(batch) && v.Id == batch[j].Id {
+				batch[j].ExternalIds = v.ExternalIds
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *SpotifyResolver) get(ctx context.Context, url string, v any) error {
+	token, err := s.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return getJson(ctx, s.Client, req, v)
+}
+
+// accessToken returns the access token of the client credentials. (cached until expired)
+func (s *SpotifyResolver) accessToken(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Now().Before(s.expires) {
+		return s.token, nil
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "https://accounts.spotify.com/api/token", strings.NewReader("grant_type=client_credentials"))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(s.ClientId, s.ClientSecret)
+
+	var data struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := getJson(ctx, s.Client, req, &data); err != nil {
+		return "", fmt.Errorf("failed to get spotify token: %w", err)
+	}
+
+	s.token = data.AccessToken
+	s.expires = time.Now().Add(time.Duration(data.ExpiresIn)*time.Second - time.Minute) // refresh a minute early
+	return s.token, nil
+}
+
+func (t spotifyTrack) toMeta(album string) TrackMeta {
+	artists := []string{}
+	for _, v := range t.Artists {
+		artists = append(artists, v.Name)
+	}
+
+	if album == "" {
+		album = t.Album.Name
+	}
+
+	return TrackMeta{
+		Title:    t.Name,
+		Artist:   strings.Join(artists, ", "),
+		Album:    album,
+		ISRC:     t.ExternalIds.ISRC,
+		Duration: time.Duration(t.DurationMs) * time.Millisecond,
+	}
+}
+
+// followRedirect returns the final URL of the link. (for the short links)
+func followRedirect(ctx context.Context, client *http.Client, url string) (*Url.URL, error) {
+	u, err := Url.ParseRequestURI(url)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+
+	switch strings.ToLower(u.Hostname()) {
+	case "deezer.page.link", "link.deezer.com", "spotify.link":
+	default: // not a short link
+		return u, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	resp.Body.Close()
+
+	return resp.Request.URL, nil
+}
+
+// capTracks limits the number of tracks. (metadataMaxTracks)
+func capTracks(tracks []TrackMeta) []TrackMeta {
+	if len(tracks) > metadataMaxTracks {
+		return tracks[:metadataMaxTracks]
+	}
+
+	return tracks
+}
@@ -0,0 +1,149 @@
+package Provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	Url "net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubTransport sends the requests of every host to the stub server.
+type stubTransport struct {
+	server *httptest.Server
+}
+
+func (t *stubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	u, _ := Url.Parse(t.server.URL)
+
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = u.Scheme, u.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// newMetadataStub starts a stub server of the streaming services, and returns the client that requests it.
+func newMetadataStub(t *testing.T, handler http.HandlerFunc) *http.Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	return &http.Client{Transport: &stubTransport{server: server}}
+}
+
+func TestSpotifyAlbumIsrc(t *testing.T) {
+	batches := 0
+	client := newMetadataStub(t, func(w http.ResponseWriter, r *http.Request) {
+		var body any
+		switch {
+		case r.URL.Path == "/api/token":
+			body = map[string]any{"access_token": "token", "expires_in": 3600}
+
+		case r.URL.Path == "/v1/albums/al1":
+			body = map[string]any{"name": "Album"}
+
+		case r.URL.Path == "/v1/albums/al1/tracks": // simplified tracks (without the ISRC)
+			items := []map[string]any{}
+			for j := range spotifyBatchSize + 1 {
+				items = append(items, map[string]any{"id": fmt.Sprint("t", j), "name": fmt.Sprint("Song ", j), "artists": []map[string]any{{"name": "Artist"}}})
+			}
+			body = map[string]any{"items": items}
+
+		case r.URL.Path == "/v1/tracks":
+			batches++
+			tracks := []any{}
+			for _, id := range strings.Split(r.URL.Query().Get("ids"), ",") {
+				if id == "t1" { // not found
+					tracks = append(tracks, nil)
+					continue
+				}
+				tracks = append(tracks, map[string]any{"id": id, "external_ids": map[string]any{"isrc": "ISRC-" + id}})
+			}
+			body = map[string]any{"tracks": tracks}
+
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		json.NewEncoder(w).Encode(body)
+	})
+
+	resolver := &SpotifyResolver{ClientId: "id", ClientSecret: "secret", Client: client}
+	tracks, err := resolver.Resolve(context.Background(), "https://open.spotify.com/album/al1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tracks) != spotifyBatchSize+1 || batches != 2 {
+		t.Fatalf("got %d tracks in %d batches", len(tracks), batches)
+	}
+	for j, v := range tracks {
+		want := fmt.Sprint("ISRC-t", j)
+		if j == 1 {
+			want = ""
+		}
+		if v.ISRC != want || v.Album != "Album" {
+			t.Errorf("#%d: got %+v, want ISRC %q", j, v, want)
+		}
+	}
+}
+
+func TestSpotifyWithoutCredentials(t *testing.T) {
+	resolver := &SpotifyResolver{Client: http.DefaultClient}
+
+	url := "https://open.spotify.com/track/4cOdK2wGLETKBW3PvgPWqT"
+	if !resolver.Match(url) {
+		t.Fatal("the link of Spotify is not matched")
+	}
+
+	// the link is not searched as a text
+	if _, err := resolver.Resolve(context.Background(), url); !errors.Is(err, ErrNotConfigured) {
+		t.Fatalf("got %v, want ErrNotConfigured", err)
+	}
+}
+
+func TestAppleMusicPlaylist(t *testing.T) {
+	page := `<html><head>
+<script type="application/ld+json">{"@type":"WebSite","name":"Apple Music"}</script>
+<script id="schema:music-playlist" type="application/ld+json">{"@context":"http://schema.org","@type":"MusicPlaylist","name":"Playlist","track":[
+	{"@type":"MusicRecording","name":"First Song","duration":"PT3M20S","byArtist":[{"@type":"MusicGroup","name":"Artist"},{"@type":"MusicGroup","name":"Guest"}],"inAlbum":{"name":"Album"}},
+	{"@type":"MusicRecording","name":"Second Song","duration":"PT1H2S","byArtist":{"@type":"MusicGroup","name":"Artist"}}
+]}</script>
+</head></html>`
+
+	client := newMetadataStub(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/us/playlist/name/pl.u-abc" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(page))
+	})
+
+	resolver := &AppleMusicResolver{Client: client}
+	url := "https://music.apple.com/us/playlist/name/pl.u-abc"
+	if !resolver.Match(url) {
+		t.Fatal("the link of the playlist is not matched")
+	}
+
+	tracks, err := resolver.Resolve(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []TrackMeta{
+		{Title: "First Song", Artist: "Artist, Guest", Album: "Album", Duration: 3*time.Minute + 20*time.Second},
+		{Title: "Second Song", Artist: "Artist", Duration: time.Hour + 2*time.Second},
+	}
+	if len(tracks) != len(want) {
+		t.Fatalf("got %+v, want %+v", tracks, want)
+	}
+	for j := range want {
+		if tracks[j] != want[j] {
+			t.Errorf("#%d: got %+v, want %+v", j, tracks[j], want[j])
+		}
+	}
+}
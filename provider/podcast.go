@@ -0,0 +1,398 @@
+package Provider
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	Url "net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thirdscam/chatanium-musicbot/util"
+	"github.com/thirdscam/chatanium/src/Util/Log"
+)
+
+// The maximum time to fetch the feed (or the chapters).
+const podcastFetchTimeout = 15 * time.Second
+
+// The maximum size of the feed.
+const podcastFeedMaxSize = 16 * 1024 * 1024
+
+// Podcast plays the episodes of the podcast feed. (RSS/Atom)
+//
+// /play enqueues the latest episode of the feed, and /search lists the recent episodes to pick.
+// the chapters of the episode are read from the Podcasting 2.0 chapters or the ID3 chapters.
+//
+// The provider only accepts the URL of the feed, so it cannot search by free text. (no CapabilitySearch)
+type Podcast struct {
+	Client *http.Client
+
+	chaptersUrls sync.Map // the Podcasting 2.0 chapters URL of the episodes (MusicID -> string)
+}
+
+func init() {
+	Register(Entry{
+		Name:         "podcast",
+		Label:        "Podcast",
+		Capabilities: CapabilityUrl,
+		Provider: &Podcast{
+			Client: &http.Client{Timeout: podcastFetchTimeout},
+		},
+		Match: IsFeedUrl,
+	})
+}
+
+// IsFeedUrl reports whether the URL looks like a podcast feed. (e.g. .rss, .xml, /feed)
+func IsFeedUrl(url string) bool {
+	u, err := Url.ParseRequestURI(url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+
+	p := strings.ToLower(strings.TrimSuffix(u.Path, "/"))
+	switch path.Ext(p) {
+	case ".rss", ".xml", ".atom":
+		return true
+	}
+
+	return strings.HasSuffix(p, "/feed") || strings.HasSuffix(p, "/rss")
+}
+
+// podcastFeed is a part of the RSS 2.0 and Atom feed that is used by the provider.
+type podcastFeed struct {
+	// RSS 2.0
+	Channel struct {
+		Title string `xml:"title"`
+		Image struct {
+			Url string `xml:"url"`
+		} `xml:"image"`
+		ItunesImage struct {
+			Href string `xml:"href,attr"`
+		} `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+		Items []podcastItem `xml:"item"`
+	} `xml:"channel"`
+
+	// Atom
+	Title   string        `xml:"title"`
+	Entries []podcastItem `xml:"entry"`
+}
+
+type podcastItem struct {
+	Title     string `xml:"title"`
+	Guid      string `xml:"guid"`
+	Id        string `xml:"id"` // Atom
+	PubDate   string `xml:"pubDate"`
+	Published string `xml:"published"` // Atom
+	Updated   string `xml:"updated"`   // Atom
+	Enclosure struct {
+		Url  string `xml:"url,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"enclosure"`
+	Links []struct { // Atom
+		Rel  string `xml:"rel,attr"`
+		Href string `xml:"href,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"link"`
+	ItunesDuration string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+	ItunesImage    struct {
+		Href string `xml:"href,attr"`
+	} `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+	Chapters struct {
+		Url  string `xml:"url,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"https://podcastindex.org/namespace/1.0 chapters"`
+}
+
+// podcastEpisode is an episode parsed from the feed.
+type podcastEpisode struct {
+	music       Music
+	chaptersUrl string // Podcasting 2.0 chapters (JSON)
+}
+
+func (p *Podcast) Start(ctx context.Context) {
+	Log.Verbose.Println("[MusicBot] Podcast provider started")
+}
+
+// GetMusic returns the latest episode of the feed.
+func (p *Podcast) GetMusic(ctx context.Context, query string) ([]Music, error) {
+	episodes, err := p.episodes(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	episode := episodes[0]
+	episode.music.Chapters = p.chapters(ctx, episode)
+
+	return []Music{episode.music}, nil
+}
+
+// Search returns the recent episodes of the feed. (query is the URL of the feed)
+//
+// Only the feed is read (title, publish date and duration), and the chapters are read when the episode is resolved.
+func (p *Podcast) Search(ctx context.Context, query string, limit int) ([]Music, error) {
+	episodes, err := p.episodes(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(episodes) > limit {
+		episodes = episodes[:limit]
+	}
+
+	result := []Music{}
+	for _, v := range episodes {
+		result = append(result, v.music)
+	}
+
+	return result, nil
+}
+
+// Resolve reads the chapters of the episode, if it has not been read. (e.g. the episode picked from /search)
+func (p *Podcast) Resolve(ctx context.Context, music Music) (Music, error) {
+	if len(music.Chapters) == 0 {
+		chaptersUrl := ""
+		if v, ok := p.chaptersUrls.Load(music.Id); ok {
+			chaptersUrl = v.(string)
+		}
+		music.Chapters = p.chapters(ctx, podcastEpisode{music: music, chaptersUrl: chaptersUrl})
+	}
+
+	return music, nil
+}
+
+// episodes fetches the feed and returns its episodes. (newest first)
+func (p *Podcast) episodes(ctx context.Context, feedUrl string) ([]podcastEpisode, error) {
+	u, err := Url.ParseRequestURI(feedUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("%w: not a http(s) url (url: %s)", ErrUnsupported, feedUrl)
+	}
+
+	body, err := p.fetch(ctx, feedUrl, podcastFeedMaxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var feed podcastFeed
+	if err := xml.Unmarshal(body, &feed); err != nil {
+		return nil, fmt.Errorf("%w: invalid feed: %v", ErrUnsupported, err)
+	}
+
+	podcastTitle := feed.Channel.Title
+	podcastImage := feed.Channel.ItunesImage.Href
+	if podcastImage == "" {
+		podcastImage = feed.Channel.Image.Url
+	}
+
+	items := feed.Channel.Items
+	if len(items) == 0 { // Atom
+		podcastTitle = feed.Title
+		items = feed.Entries
+	}
+
+	result := []podcastEpisode{}
+	for _, v := range items {
+		enclosure := v.enclosure()
+		if enclosure == "" { // not an episode with audio
+			continue
+		}
+
+		thumbnail := v.ItunesImage.Href
+		if thumbnail == "" {
+			thumbnail = podcastImage
+		}
+
+		id := v.Guid
+		if id == "" {
+			id = v.Id
+		}
+		if id == "" {
+			id = enclosure
+		}
+
+		musicId := MusicID("PODCAST:" + util.GetSha256Hash(feedUrl+":"+id))
+		if v.Chapters.Url != "" {
+			p.chaptersUrls.Store(musicId, v.Chapters.Url)
+		}
+
+		result = append(result, podcastEpisode{
+			music: Music{
+				Id:           musicId,
+				Title:        strings.TrimSpace(v.Title),
+				RawUrl:       enclosure,
+				Type:         "podcast",
+				ThumbnailUrl: thumbnail,
+				Duration:     parseItunesDuration(v.ItunesDuration),
+				Uploader:     strings.TrimSpace(podcastTitle),
+				WebpageUrl:   enclosure,
+				Published:    v.published(),
+			},
+			chaptersUrl: v.Chapters.Url,
+		})
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("%w: no episodes in the feed (url: %s)", ErrNotFound, feedUrl)
+	}
+
+	sort.SliceStable(result, func(a, b int) bool {
+		return result[a].music.Published.After(result[b].music.Published)
+	})
+
+	return result, nil
+}
+
+// chapters returns the chapters of the episode.
+// the Podcasting 2.0 chapters are preferred, and the ID3 chapters of the enclosure are used otherwise.
+func (p *Podcast) chapters(ctx context.Context, episode podcastEpisode) []Chapter {
+	ctx, cancel := context.WithTimeout(ctx, podcastFetchTimeout)
+	defer cancel()
+
+	if episode.chaptersUrl != "" {
+		chapters, err := p.jsonChapters(ctx, episode.chaptersUrl, episode.music.Duration)
+		if err == nil {
+			return chapters
+		}
+		Log.Verbose.Printf("[MusicBot] Failed to read chapters (%s): %v", episode.chaptersUrl, err)
+	}
+
+	probe, err := ffprobe(ctx, episode.music.RawUrl)
+	if err != nil {
+		Log.Verbose.Printf("[MusicBot] Failed to read ID3 chapters (%s): %v", episode.music.RawUrl, err)
+		return []Chapter{}
+	}
+
+	return probe.Chapters
+}
+
+// jsonChapters reads the Podcasting 2.0 chapters. (https://github.com/Podcastindex-org/podcast-namespace/blob/main/chapters/jsonChapters.md)
+func (p *Podcast) jsonChapters(ctx context.Context, url string, duration time.Duration) ([]Chapter, error) {
+	body, err := p.fetch(ctx, url, podcastFeedMaxSize)
+	if err != nil {
+		return nil, err
+	}
+
+	var data struct {
+		Chapters []struct {
+			StartTime float64  `json:"startTime"`
+			EndTime   *float64 `json:"endTime"`
+			Title     string   `json:"title"`
+			Toc       *bool    `json:"toc"`
+		} `json:"chapters"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, fmt.Errorf("invalid chapters: %w", err)
+	}
+
+	result := []Chapter{}
+	for _, v := range data.Chapters {
+		if v.Toc != nil && !*v.Toc { // not a part of the table of contents
+			continue
+		}
+
+		result = append(result, Chapter{
+			Title: v.Title,
+			Start: seconds(v.StartTime),
+		})
+		if v.EndTime != nil {
+			result[len(result)-1].End = seconds(*v.EndTime)
+		}
+	}
+
+	// the end of the chapter is the start of the next one (if not given)
+	for i := range result {
+		if result[i].End != 0 {
+			continue
+		}
+		if i+1 < len(result) {
+			result[i].End = result[i+1].Start
+		} else {
+			result[i].End = duration
+		}
+	}
+
+	return result, nil
+}
+
+// fetch reads the body of the URL up to maxSize bytes.
+func (p *Podcast) fetch(ctx context.Context, url string, maxSize int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return nil, fmt.Errorf("%w: HTTP %d (url: %s)", ErrNotFound, resp.StatusCode, url)
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("%w: HTTP %d (url: %s)", ErrUnavailable, resp.StatusCode, url)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	if int64(len(body)) > maxSize {
+		return nil, fmt.Errorf("%w: larger than %d bytes (url: %s)", ErrTooLarge, maxSize, url)
+	}
+
+	return body, nil
+}
+
+// enclosure returns the URL of the audio of the episode.
+// (only the http(s) links, the feed must not point at the files of the host)
+func (i podcastItem) enclosure() string {
+	if util.IsUrl(i.Enclosure.Url) && (i.Enclosure.Type == "" || strings.HasPrefix(i.Enclosure.Type, "audio/") || strings.HasPrefix(i.Enclosure.Type, "video/")) {
+		return i.Enclosure.Url
+	}
+
+	for _, v := range i.Links {
+		if v.Rel == "enclosure" && util.IsUrl(v.Href) && (v.Type == "" || strings.HasPrefix(v.Type, "audio/")) {
+			return v.Href
+		}
+	}
+
+	return ""
+}
+
+// published returns the publish date of the episode. (zero if unknown)
+func (i podcastItem) published() time.Time {
+	for _, v := range []string{i.PubDate, i.Published, i.Updated} {
+		for _, layout := range []string{time.RFC1123Z, time.RFC1123, time.RFC3339, "Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST"} {
+			if t, err := time.Parse(layout, strings.TrimSpace(v)); err == nil {
+				return t
+			}
+		}
+	}
+
+	return time.Time{}
+}
+
+// parseItunesDuration parses the duration of the episode. (e.g. "3600", "59:59", "1:02:03")
+func parseItunesDuration(s string) time.Duration {
+	result := time.Duration(0)
+	for _, v := range strings.Split(strings.TrimSpace(s), ":") {
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0
+		}
+		result = result*60 + seconds(n)
+	}
+
+	return result
+}
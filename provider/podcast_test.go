@@ -0,0 +1,34 @@
+package Provider
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func TestPodcastEnclosure(t *testing.T) {
+	tests := []struct {
+		item string
+		want string
+	}{
+		{`<item><enclosure url="https://example.com/ep1.mp3" type="audio/mpeg"/></item>`, "https://example.com/ep1.mp3"},
+		{`<item><enclosure url="https://example.com/ep1.html" type="text/html"/></item>`, ""},
+		{`<entry><link rel="enclosure" href="http://example.com/ep2.m4a" type="audio/mp4"/></entry>`, "http://example.com/ep2.m4a"},
+
+		// the files of the host are never played
+		{`<item><enclosure url="file:///etc/passwd" type="audio/mpeg"/></item>`, ""},
+		{`<item><enclosure url="/etc/passwd"/></item>`, ""},
+		{`<item><enclosure url="-version"/></item>`, ""},
+		{`<entry><link rel="enclosure" href="file:///etc/passwd"/></entry>`, ""},
+	}
+
+	for _, tt := range tests {
+		var item podcastItem
+		if err := xml.Unmarshal([]byte(tt.item), &item); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := item.enclosure(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.item, got, tt.want)
+		}
+	}
+}
@@ -1,5 +1,14 @@
 package Provider
 
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
 // MusicID is a unique identifier for a music.
 // It is used to download file name as MusicID.
 //
@@ -9,20 +18,205 @@ type MusicID string
 type Music struct {
 	Id     MusicID // unique identifier for the music
 	Title  string
-	RawUrl string
-	Type   string
+	RawUrl string // media URL at the time of the query (can expire, use Interface.Resolve())
+	Type   string // name of the provider
 
 	ThumbnailUrl string
-	Duration     string
+	Duration     time.Duration // zero if unknown (or live)
+	Uploader     string
+	WebpageUrl   string // stable URL of the page of the music (used to resolve the media URL again)
+	IsLive       bool
+	Chapters     []Chapter
+	Published    time.Time // zero if unknown (e.g. the episode of the podcast)
+
+	// set when the music is matched from a track of another service (e.g. Spotify link)
+	MatchedFrom string  // "Artist - Title" of the original track
+	Confidence  float64 // how likely the music is the original track (0.0 ~ 1.0)
+
+	// set by the bot to tell the entries of the same music in a queue apart (e.g. the entries of an import job)
+	Tag string
+}
+
+// Chapter is a section of the music.
+type Chapter struct {
+	Title string
+	Start time.Duration
+	End   time.Duration
+}
+
+// SkippedEntry is an entry of the query that the provider could not resolve.
+type SkippedEntry struct {
+	Id     string // identifier of the entry in the provider (can be empty)
+	Title  string // title of the entry (can be empty)
+	Reason error
+}
+
+// PartialError is returned with the result when some entries of the query are skipped.
+//
+// The result returned with PartialError is still valid, so the caller can use it.
+type PartialError struct {
+	Skipped []SkippedEntry
+}
+
+func (e *PartialError) Error() string {
+	reasons := []string{}
+	for _, v := range e.Skipped {
+		reasons = append(reasons, fmt.Sprintf("%s: %v", v.Id, v.Reason))
+	}
+
+	return fmt.Sprintf("%d entries skipped (%s)", len(e.Skipped), strings.Join(reasons, ", "))
 }
 
 type Interface interface {
-	Start()
-	GetMusic(query string) ([]Music, error)
+	// Start prepares the provider. background jobs of the provider must stop when ctx is done.
+	Start(ctx context.Context)
+
+	// GetMusic queries the music. it must give up (and kill any child process) when ctx is done.
+	GetMusic(ctx context.Context, query string) ([]Music, error)
+
+	// Search returns up to limit candidates of the query, so that the user can pick one of them.
+	Search(ctx context.Context, query string, limit int) ([]Music, error)
+
+	// Resolve returns the music with a fresh media URL (RawUrl).
+	// media URLs can expire (e.g. googlevideo links), so it is called right before the download.
+	// the details that the query result lacks (chapters, duration, live status) are filled in if possible. (e.g. flat playlist)
+	Resolve(ctx context.Context, music Music) (Music, error)
+}
+
+// ArtworkProvider is implemented by the providers whose music has cover art without URL. (e.g. local files)
+type ArtworkProvider interface {
+	// Artwork returns the image of the cover art of the music.
+	Artwork(ctx context.Context, music Music) ([]byte, error)
+}
+
+// Streamer is implemented by the providers whose music is a live stream. (e.g. internet radio)
+//
+// The live music is not downloaded to the file, but played from the stream directly.
+type Streamer interface {
+	// Stream opens the audio stream of the music. it is closed when ctx is done.
+	// onTitle is called whenever the current song of the stream changes.
+	Stream(ctx context.Context, music Music, onTitle func(title string)) (io.ReadCloser, error)
+}
+
+// Downloader is implemented by the providers that read the media by themselves. (e.g. to limit the size)
+//
+// The media is passed to the encoder from the reader, instead of the media URL.
+type Downloader interface {
+	// Open opens the media of the URL resolved by Resolve. it is closed when ctx is done.
+	// reading it fails if the media turns out to be invalid. (e.g. ErrTooLarge)
+	Open(ctx context.Context, url string) (io.ReadCloser, error)
+}
+
+// Playlist is a playlist saved in the provider.
+type Playlist struct {
+	Name      string
+	Query     string // the query of GetMusic to enqueue the playlist
+	SongCount int
+}
+
+// PlaylistLister is implemented by the providers that keep the saved playlists. (e.g. media server)
+type PlaylistLister interface {
+	// Playlists returns the saved playlists of the provider.
+	Playlists(ctx context.Context) ([]Playlist, error)
+}
+
+// Capability is a set of features that a provider supports.
+type Capability uint8
+
+const (
+	CapabilitySearch   Capability = 1 << iota // can search music by free text
+	CapabilityUrl                             // can resolve music from a URL
+	CapabilityPlaylist                        // can expand a playlist URL into multiple musics
+)
+
+// Has reports whether all of the given capabilities are supported.
+func (c Capability) Has(flag Capability) bool {
+	return c&flag == flag
+}
+
+// Entry is a provider registered to the registry.
+type Entry struct {
+	Name         string // unique name of the provider (used as command option value)
+	Label        string // human readable name of the provider
+	Capabilities Capability
+	Provider     Interface
+
+	// Match reports whether the provider can handle the URL.
+	// it is used to detect the provider automatically from the query.
+	// (nil means the provider never claims a URL)
+	Match func(url string) bool
+
+	// Fallback means the provider claims the URL only if no other provider claims it.
+	// (e.g. the generic provider that claims any URL)
+	Fallback bool
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = []Entry{}
+)
+
+// Register adds a provider to the registry.
+//
+// It should be called from init() of each provider, and panics if the name is already registered.
+func Register(e Entry) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	for _, v := range registry {
+		if v.Name == e.Name {
+			panic(fmt.Sprintf("provider already registered: %s", e.Name))
+		}
+	}
+
+	registry = append(registry, e)
+}
+
+// Entries returns all registered providers in registration order.
+func Entries() []Entry {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	return append([]Entry{}, registry...)
+}
+
+// Get returns the registered provider by name.
+func Get(name string) (Entry, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	for _, v := range registry {
+		if v.Name == name {
+			return v, true
+		}
+	}
+
+	return Entry{}, false
+}
+
+// Detect returns the provider that claims the query as its URL.
+// if the query is not a URL of any provider, it returns false.
+func Detect(query string) (Entry, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	// the fallback providers are checked after all other providers
+	for _, isFallback := range []bool{false, true} {
+		for _, v := range registry {
+			if v.Fallback == isFallback && v.Match != nil && v.Capabilities.Has(CapabilityUrl) && v.Match(query) {
+				return v, true
+			}
+		}
+	}
+
+	return Entry{}, false
 }
 
 func GetProviders() map[string]Interface {
-	return map[string]Interface{
-		"youtube": &Youtube{},
+	result := map[string]Interface{}
+	for _, v := range Entries() {
+		result[v.Name] = v.Provider
 	}
+
+	return result
 }
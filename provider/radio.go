@@ -0,0 +1,344 @@
+package Provider
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	Url "net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thirdscam/chatanium-musicbot/util"
+	"github.com/thirdscam/chatanium/src/Util/Log"
+)
+
+// The maximum time to connect to the station.
+const radioConnectTimeout = 15 * time.Second
+
+// The maximum size of a station file. (PLS/M3U)
+const radioPlaylistMaxSize = 64 * 1024
+
+// Radio plays the internet radio streams. (Icecast/SHOUTcast)
+//
+// The query is the URL of the stream or the station file. (PLS/M3U)
+// the stream is not downloaded, but played live (see Streamer).
+type Radio struct {
+	Client *http.Client
+}
+
+func init() {
+	Register(Entry{
+		Name:         "radio",
+		Label:        "Internet radio",
+		Capabilities: CapabilityUrl,
+		Provider: &Radio{
+			// no timeout, because the stream never ends (the context stops it)
+			Client: &http.Client{},
+		},
+		Match: IsStationUrl,
+	})
+}
+
+// IsStationUrl reports whether the URL is a http(s) link of a station file. (PLS/M3U)
+func IsStationUrl(url string) bool {
+	u, err := Url.ParseRequestURI(url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+
+	ext := strings.ToLower(path.Ext(u.Path))
+	return ext == ".pls" || ext == ".m3u"
+}
+
+func (r *Radio) Start(ctx context.Context) {
+	Log.Verbose.Println("[MusicBot] Internet radio provider started")
+}
+
+func (r *Radio) GetMusic(ctx context.Context, query string) ([]Music, error) {
+	u, err := Url.ParseRequestURI(query)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("%w: not a http(s) url (url: %s)", ErrUnsupported, query)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, radioConnectTimeout)
+	defer cancel()
+
+	resp, err := r.open(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+
+	// 1. the station file lists the streams of the station
+	if IsStationUrl(query) || isStationContentType(contentType) {
+		streams, err := parseStation(io.LimitReader(resp.Body, radioPlaylistMaxSize))
+		if err != nil {
+			return nil, err
+		}
+
+		// use the first stream that is available
+		for _, v := range streams {
+			music, err := r.probe(ctx, v.url, v.title)
+			if err != nil {
+				Log.Verbose.Printf("[MusicBot] Station stream unavailable (%s): %v", v.url, err)
+				continue
+			}
+
+			return []Music{music}, nil
+		}
+
+		return nil, fmt.Errorf("%w: no stream of the station is available (url: %s)", ErrUnavailable, query)
+	}
+
+	// 2. else, the URL is the stream itself
+	music, err := r.musicFromResponse(query, "", resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return []Music{music}, nil
+}
+
+// Search only accepts a URL, because there is no directory of the stations.
+func (r *Radio) Search(ctx context.Context, query string, limit int) ([]Music, error) {
+	return r.GetMusic(ctx, query)
+}
+
+func (r *Radio) Resolve(ctx context.Context, music Music) (Music, error) {
+	return music, nil
+}
+
+// Stream opens the audio stream of the station.
+// onTitle is called with the ICY StreamTitle whenever the current song of the station changes.
+func (r *Radio) Stream(ctx context.Context, music Music, onTitle func(title string)) (io.ReadCloser, error) {
+	resp, err := r.open(ctx, music.RawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 400 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: HTTP %d (url: %s)", ErrUnavailable, resp.StatusCode, music.RawUrl)
+	}
+
+	metaint, err := strconv.Atoi(resp.Header.Get("icy-metaint"))
+	if err != nil || metaint <= 0 { // the station does not send the metadata
+		return resp.Body, nil
+	}
+
+	return &icyReader{
+		body:      resp.Body,
+		reader:    bufio.NewReader(resp.Body),
+		metaint:   metaint,
+		remaining: metaint,
+		onTitle:   onTitle,
+	}, nil
+}
+
+// open requests the URL with the ICY metadata.
+func (r *Radio) open(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	req.Header.Set("Icy-MetaData", "1")
+
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	return resp, nil
+}
+
+// probe connects to the stream to check that it is available.
+func (r *Radio) probe(ctx context.Context, url, title string) (Music, error) {
+	resp, err := r.open(ctx, url)
+	if err != nil {
+		return Music{}, err
+	}
+	defer resp.Body.Close()
+
+	return r.musicFromResponse(url, title, resp)
+}
+
+// musicFromResponse builds the music from the response of the stream. (name of the station, etc.)
+func (r *Radio) musicFromResponse(url, title string, resp *http.Response) (Music, error) {
+	if resp.StatusCode >= 400 {
+		return Music{}, fmt.Errorf("%w: HTTP %d (url: %s)", ErrUnavailable, resp.StatusCode, url)
+	}
+
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(contentType, "audio/") && contentType != "application/ogg" && contentType != "video/ogg" {
+		return Music{}, fmt.Errorf("%w: content type is %q (url: %s)", ErrUnsupported, contentType, url)
+	}
+
+	if name := strings.TrimSpace(resp.Header.Get("icy-name")); name != "" {
+		title = name
+	}
+	if title == "" {
+		u, _ := Url.Parse(url)
+		title = u.Host
+	}
+
+	return Music{
+		Id:         MusicID("RADIO:" + util.GetSha256Hash(url)),
+		Title:      title,
+		RawUrl:     url,
+		Type:       "radio",
+		Uploader:   strings.TrimSpace(resp.Header.Get("icy-genre")),
+		WebpageUrl: strings.TrimSpace(resp.Header.Get("icy-url")),
+		IsLive:     true,
+	}, nil
+}
+
+// isStationContentType reports whether the content type is a station file. (PLS/M3U)
+func isStationContentType(contentType string) bool {
+	switch contentType {
+	case "audio/x-scpls", "audio/scpls", "audio/x-mpegurl", "audio/mpegurl", "application/x-mpegurl", "application/pls+xml":
+		return true
+	}
+
+	return false
+}
+
+type stationStream struct {
+	url    string
+	title  string
+	plsKey string // number of the PLS entry (e.g. "1" of "File1")
+}
+
+// parseStation parses the station file (PLS/M3U) into the streams.
+func parseStation(r io.Reader) ([]stationStream, error) {
+	result := []stationStream{}
+	plsTitles := map[string]string{}
+	m3uTitle := ""
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		// PLS: "File1=http://...", "Title1=..."
+		case strings.HasPrefix(strings.ToLower(line), "file"):
+			key, value, ok := strings.Cut(line, "=")
+			if ok && util.IsUrl(strings.TrimSpace(value)) {
+				result = append(result, stationStream{url: strings.TrimSpace(value), plsKey: key[4:]})
+			}
+
+		case strings.HasPrefix(strings.ToLower(line), "title"):
+			key, value, ok := strings.Cut(line, "=")
+			if ok {
+				plsTitles[key[5:]] = strings.TrimSpace(value)
+			}
+
+		// M3U: "#EXTINF:-1,Title" followed by the URL
+		case strings.HasPrefix(line, "#EXTINF:"):
+			if _, title, ok := strings.Cut(line, ","); ok {
+				m3uTitle = strings.TrimSpace(title)
+			}
+
+		case line == "", strings.HasPrefix(line, "#"), strings.HasPrefix(line, "["):
+			continue
+
+		case util.IsUrl(line):
+			result = append(result, stationStream{url: line, title: m3uTitle})
+			m3uTitle = ""
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: invalid station file: %v", ErrUnsupported, err)
+	}
+
+	// the title of the PLS entry is given by its number
+	for i, v := range result {
+		if v.plsKey != "" {
+			result[i].title = plsTitles[v.plsKey]
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("%w: no stream in the station file", ErrNotFound)
+	}
+
+	return result, nil
+}
+
+// icyTitle matches the title of the ICY metadata. (e.g. "StreamTitle='Artist - Song';StreamUrl=”;")
+var icyTitle = regexp.MustCompile(`StreamTitle='(.*?)';`)
+
+// icyReader strips the ICY metadata from the stream, and reports the changes of the title.
+//
+// The metadata is inserted every metaint bytes of the audio,
+// as a length byte (x16) followed by the metadata string.
+type icyReader struct {
+	body      io.Closer
+	reader    *bufio.Reader
+	metaint   int
+	remaining int // bytes of the audio until the next metadata
+	title     string
+	onTitle   func(title string)
+}
+
+func (r *icyReader) Read(p []byte) (int, error) {
+	if r.remaining == 0 {
+		if err := r.readMetadata(); err != nil {
+			return 0, err
+		}
+		r.remaining = r.metaint
+	}
+
+	if len(p) > r.remaining {
+		p = p[:r.remaining]
+	}
+
+	n, err := r.reader.Read(p)
+	r.remaining -= n
+	return n, err
+}
+
+func (r *icyReader) readMetadata() error {
+	length, err := r.reader.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	if length == 0 { // no changes
+		return nil
+	}
+
+	metadata := make([]byte, int(length)*16)
+	if _, err := io.ReadFull(r.reader, metadata); err != nil {
+		return err
+	}
+
+	match := icyTitle.FindSubmatch(metadata)
+	if match == nil {
+		return nil
+	}
+
+	title := strings.TrimSpace(string(match[1]))
+	if title != "" && title != r.title {
+		r.title = title
+		if r.onTitle != nil {
+			r.onTitle(title)
+		}
+	}
+
+	return nil
+}
+
+func (r *icyReader) Close() error {
+	return r.body.Close()
+}
@@ -0,0 +1,302 @@
+package Provider
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/thirdscam/chatanium/src/Util/Log"
+)
+
+// The maximum time to wait for the API of the streaming services.
+const streamingTimeout = 15 * time.Second
+
+// The number of candidates to compare for each track.
+const streamingCandidates = 5
+
+// The number of tracks to match at the same time.
+const streamingConcurrency = 4
+
+// LowConfidence is the confidence below which the match should be reported to the user.
+const LowConfidence = 0.6
+
+// Streaming plays the links of the streaming services that cannot be played directly. (Spotify, Apple Music, Deezer)
+//
+// The metadata of the tracks are read by the resolvers,
+// and each track is matched to a playable music of the matcher. (YouTube)
+type Streaming struct {
+	Resolvers []MetadataResolver
+	Matcher   string // name of the provider to match the tracks
+}
+
+func init() {
+	client := &http.Client{Timeout: streamingTimeout}
+
+	// the links of Spotify are rejected with ErrNotConfigured without the client credentials (not searched as a text)
+	resolvers := []MetadataResolver{
+		&DeezerResolver{Client: client},
+		&AppleMusicResolver{Client: client},
+		&SpotifyResolver{ClientId: os.Getenv("MUSICBOT_SPOTIFY_CLIENT_ID"), ClientSecret: os.Getenv("MUSICBOT_SPOTIFY_CLIENT_SECRET"), Client: client},
+	}
+
+	streaming := &Streaming{Resolvers: resolvers, Matcher: "youtube"}
+	Register(Entry{
+		Name:         "streaming",
+		Label:        "Spotify / Apple Music / Deezer",
+		Capabilities: CapabilityUrl | CapabilityPlaylist,
+		Provider:     streaming,
+		Match: func(url string) bool {
+			return streaming.resolver(url) != nil
+		},
+	})
+}
+
+func (s *Streaming) Start(ctx context.Context) {
+	Log.Verbose.Printf("[MusicBot] Streaming link provider started (%d services)", len(s.Resolvers))
+}
+
+func (s *Streaming) GetMusic(ctx context.Context, query string) ([]Music, error) {
+	resolver := s.resolver(query)
+	if resolver == nil {
+		return nil, fmt.Errorf("%w: not a link of the streaming services (url: %s)", ErrUnsupported, query)
+	}
+
+	tracks, err := resolver.Resolve(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.matchAll(ctx, tracks)
+}
+
+func (s *Streaming) Search(ctx context.Context, query string, limit int) ([]Music, error) {
+	result, err := s.GetMusic(ctx, query)
+	if len(result) > limit {
+		result = result[:limit]
+	}
+
+	return result, err
+}
+
+// Resolve is not used normally, because the matched music belongs to the matcher.
+func (s *Streaming) Resolve(ctx context.Context, music Music) (Music, error) {
+	matcher, err := s.matcher()
+	if err != nil {
+		return Music{}, err
+	}
+
+	return matcher.Resolve(ctx, music)
+}
+
+// resolver returns the resolver that handles the URL. (nil if not found)
+func (s *Streaming) resolver(url string) MetadataResolver {
+	for _, v := range s.Resolvers {
+		if v.Match(url) {
+			return v
+		}
+	}
+
+	return nil
+}
+
+func (s *Streaming) matcher() (Interface, error) {
+	entry, ok := Get(s.Matcher)
+	if !ok {
+		return nil, fmt.Errorf("%w: matcher provider not found (name: %s)", ErrUnavailable, s.Matcher)
+	}
+
+	return entry.Provider, nil
+}
+
+// matchAll matches the tracks concurrently. (in the order of the tracks)
+//
+// The tracks that cannot be matched are skipped and returned as PartialError.
+func (s *Streaming) matchAll(ctx context.Context, tracks []TrackMeta) ([]Music, error) {
+	// e.g. empty playlist or album
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("%w: no tracks in the link", ErrNotFound)
+	}
+
+	matcher, err := s.matcher()
+	if err != nil {
+		return nil, err
+	}
+
+	matched := make([]*Music, len(tracks))
+	errs := make([]error, len(tracks))
+
+	wg := sync.WaitGroup{}
+	sem := make(chan struct{}, streamingConcurrency)
+	for j, track := range tracks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				errs[j] = ctx.Err()
+				return
+			}
+
+			music, err := matchTrack(ctx, matcher, track)
+			if err != nil {
+				errs[j] = err
+				return
+			}
+			matched[j] = &music
+		}()
+	}
+	wg.Wait()
+
+	result := []Music{}
+	skipped := []SkippedEntry{}
+	for j, v := range matched {
+		if v == nil {
+			skipped = append(skipped, SkippedEntry{Id: tracks[j].ISRC, Title: tracks[j].String(), Reason: errs[j]})
+			continue
+		}
+		result = append(result, *v)
+	}
+
+	if len(result) == 0 {
+		return nil, skipped[0].Reason
+	}
+
+	if len(skipped) > 0 {
+		Log.Verbose.Printf("[MusicBot] %d of %d tracks are not matched", len(skipped), len(tracks))
+		return result, &PartialError{Skipped: skipped}
+	}
+
+	return result, nil
+}
+
+// matchTrack searches the track with the matcher, and picks the most similar candidate.
+//
+// The track is searched by its ISRC first (the official uploads have it in the description),
+// and by "Artist - Title" if no candidate of the ISRC is confident enough.
+func matchTrack(ctx context.Context, matcher Interface, track TrackMeta) (Music, error) {
+	if track.ISRC != "" {
+		candidates, err := matcher.Search(ctx, fmt.Sprintf("%q", track.ISRC), streamingCandidates)
+		if err != nil && ctx.Err() != nil {
+			return Music{}, ctx.Err()
+		}
+
+		if music, score, ok := bestCandidate(track, candidates); ok && score >= LowConfidence {
+			return matched(track, music, score), nil
+		}
+	}
+
+	candidates, err := matcher.Search(ctx, track.String(), streamingCandidates)
+	if err != nil {
+		return Music{}, err
+	}
+
+	music, score, ok := bestCandidate(track, candidates)
+	if !ok {
+		return Music{}, fmt.Errorf("%w: no candidates (track: %s)", ErrNotFound, track)
+	}
+
+	return matched(track, music, score), nil
+}
+
+// bestCandidate returns the most similar candidate of the track, and its score. (false if no candidates)
+func bestCandidate(track TrackMeta, candidates []Music) (Music, float64, bool) {
+	best, bestScore := -1, -1.0
+	for j, v := range candidates {
+		if score := matchScore(track, v); score > bestScore {
+			best, bestScore = j, score
+		}
+	}
+
+	if best < 0 {
+		return Music{}, 0, false
+	}
+
+	return candidates[best], bestScore, true
+}
+
+// matched marks the music as matched from the track.
+func matched(track TrackMeta, music Music, score float64) Music {
+	music.MatchedFrom = track.String()
+	music.Confidence = score
+	if score < LowConfidence {
+		Log.Verbose.Printf("[MusicBot] Low-confidence match (%.2f): %s -> %s", score, track, music.Title)
+	}
+
+	return music
+}
+
+// The words of the alternative versions. (penalized if the track does not have them)
+var variantWords = []string{"live", "cover", "remix", "karaoke", "instrumental", "acoustic", "nightcore", "sped", "slowed", "reverb", "8d", "reaction", "lyrics"}
+
+// matchScore returns how likely the candidate is the track. (0.0 ~ 1.0)
+//
+// It compares the words of the title and the artist, and the duration if both are known.
+func matchScore(track TrackMeta, candidate Music) float64 {
+	trackWords := words(track.Title)
+	candidateWords := words(candidate.Title + " " + candidate.Uploader)
+
+	score := 0.7*coverage(trackWords, candidateWords) + 0.3*coverage(words(track.Artist), candidateWords)
+
+	// the alternative versions are not the track (unless the track itself is)
+	for _, v := range variantWords {
+		if candidateWords[v] && !trackWords[v] {
+			score -= 0.3
+			break
+		}
+	}
+
+	// the duration is the most reliable hint of the same recording
+	if track.Duration > 0 && candidate.Duration > 0 {
+		diff := math.Abs((track.Duration - candidate.Duration).Seconds())
+		durationScore := math.Max(0, math.Min(1, (30-diff)/27)) // full score within 3 seconds, zero after 30 seconds
+		score = 0.6*score + 0.4*durationScore
+	}
+
+	return math.Max(0, math.Min(1, score))
+}
+
+// words returns the set of the lowercase words of s.
+func words(s string) map[string]bool {
+	result := map[string]bool{}
+	for _, v := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		result[v] = true
+	}
+
+	return result
+}
+
+// coverage returns the ratio of the words of a that are in b.
+func coverage(a, b map[string]bool) float64 {
+	if len(a) == 0 {
+		return 0
+	}
+
+	count := 0
+	for v := range a {
+		if b[v] {
+			count++
+		}
+	}
+
+	return float64(count) / float64(len(a))
+}
+
+// String returns "Artist - Title" of the track. (also used as the search query)
+func (t TrackMeta) String() string {
+	if t.Artist == "" {
+		return t.Title
+	}
+
+	return t.Artist + " - " + t.Title
+}
@@ -0,0 +1,190 @@
+package Provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// streamingFixture is the tracks of a link and the search results of the matcher. (testdata/streaming.json)
+type streamingFixture struct {
+	Tracks []struct {
+		Title    string `json:"title"`
+		Artist   string `json:"artist"`
+		Album    string `json:"album"`
+		ISRC     string `json:"isrc"`
+		Duration int    `json:"duration"`
+	} `json:"tracks"`
+	Searches map[string][]struct {
+		Id       string `json:"id"`
+		Title    string `json:"title"`
+		Uploader string `json:"uploader"`
+		Duration int    `json:"duration"`
+	} `json:"searches"`
+}
+
+// fixtureResolver resolves every "https://fixture.test/" link to the tracks of the fixture.
+type fixtureResolver struct {
+	tracks []TrackMeta
+}
+
+func (r *fixtureResolver) Match(url string) bool {
+	return strings.HasPrefix(url, "https://fixture.test/")
+}
+
+func (r *fixtureResolver) Resolve(ctx context.Context, url string) ([]TrackMeta, error) {
+	return r.tracks, nil
+}
+
+// fixtureMatcher answers the searches of the fixture, and records the queries.
+type fixtureMatcher struct {
+	mu       sync.Mutex
+	searches map[string][]Music
+	queries  []string
+}
+
+func (m *fixtureMatcher) Start(ctx context.Context) {}
+
+func (m *fixtureMatcher) GetMusic(ctx context.Context, query string) ([]Music, error) {
+	return m.Search(ctx, query, 1)
+}
+
+func (m *fixtureMatcher) Search(ctx context.Context, query string, limit int) ([]Music, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.queries = append(m.queries, query)
+	result, ok := m.searches[query]
+	if !ok {
+		return nil, fmt.Errorf("%w: no results found (query: %s)", ErrNotFound, query)
+	}
+
+	return result, nil
+}
+
+func (m *fixtureMatcher) Resolve(ctx context.Context, music Music) (Music, error) {
+	return music, nil
+}
+
+var testMatcher = &fixtureMatcher{}
+
+func init() {
+	Register(Entry{Name: "fixture-matcher", Label: "Fixture", Provider: testMatcher})
+}
+
+// loadStreamingFixture loads the fixture into the resolver and the matcher.
+func loadStreamingFixture(t *testing.T) *fixtureResolver {
+	data, err := os.ReadFile("testdata/streaming.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fixture streamingFixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := &fixtureResolver{}
+	for _, v := range fixture.Tracks {
+		resolver.tracks = append(resolver.tracks, TrackMeta{
+			Title:    v.Title,
+			Artist:   v.Artist,
+			Album:    v.Album,
+			ISRC:     v.ISRC,
+			Duration: time.Duration(v.Duration) * time.Second,
+		})
+	}
+
+	searches := map[string][]Music{}
+	for query, results := range fixture.Searches {
+		searches[query] = []Music{}
+		for _, v := range results {
+			searches[query] = append(searches[query], Music{
+				Id:       MusicID(v.Id),
+				Title:    v.Title,
+				Uploader: v.Uploader,
+				Duration: time.Duration(v.Duration) * time.Second,
+				Type:     "fixture-matcher",
+			})
+		}
+	}
+
+	testMatcher.mu.Lock()
+	testMatcher.searches = searches
+	testMatcher.queries = nil
+	testMatcher.mu.Unlock()
+
+	return resolver
+}
+
+func TestStreamingMatch(t *testing.T) {
+	resolver := loadStreamingFixture(t)
+	s := &Streaming{Resolvers: []MetadataResolver{resolver}, Matcher: "fixture-matcher"}
+
+	m, err := s.GetMusic(context.Background(), "https://fixture.test/album/1")
+
+	// the track without any candidate is skipped, and the rest is still valid
+	var partial *PartialError
+	if !errors.As(err, &partial) || len(partial.Skipped) != 1 || partial.Skipped[0].Title != "Nobody - Unknown Song" {
+		t.Fatalf("got %v, want PartialError of the unknown song", err)
+	}
+
+	got := []MusicID{}
+	for _, v := range m {
+		got = append(got, v.Id)
+	}
+
+	// 1. matched by the ISRC (the title search has only the live version)
+	// 2. the ISRC has no results, so matched by the title and the duration (not the live version)
+	// 3. matched, but with low confidence
+	want := []MusicID{"yt-1", "yt-4", "yt-5"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("matched %v, want %v", got, want)
+	}
+
+	if m[0].MatchedFrom != "The Weeknd - Blinding Lights" || m[0].Confidence < LowConfidence {
+		t.Errorf("unexpected match of the ISRC: %+v", m[0])
+	}
+	if m[1].Confidence < LowConfidence {
+		t.Errorf("unexpected confidence of the title match: %.2f", m[1].Confidence)
+	}
+	if m[2].Confidence >= LowConfidence {
+		t.Errorf("unexpected confidence of the unrelated video: %.2f", m[2].Confidence)
+	}
+
+	// the title is not searched when the ISRC is matched
+	for _, v := range testMatcher.queries {
+		if v == "The Weeknd - Blinding Lights" {
+			t.Errorf("searched the title of the track matched by the ISRC")
+		}
+	}
+}
+
+func TestStreamingEmpty(t *testing.T) {
+	loadStreamingFixture(t)
+	s := &Streaming{Resolvers: []MetadataResolver{&fixtureResolver{}}, Matcher: "fixture-matcher"}
+
+	// e.g. empty playlist
+	_, err := s.GetMusic(context.Background(), "https://fixture.test/playlist/empty")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("got %v, want ErrNotFound", err)
+	}
+}
+
+func TestMatchScore(t *testing.T) {
+	track := TrackMeta{Title: "Levitating", Artist: "Dua Lipa", Duration: 203 * time.Second}
+
+	official := matchScore(track, Music{Title: "Levitating (Official Audio)", Uploader: "Dua Lipa", Duration: 204 * time.Second})
+	remix := matchScore(track, Music{Title: "Levitating (Remix)", Uploader: "Dua Lipa", Duration: 204 * time.Second})
+	longer := matchScore(track, Music{Title: "Levitating", Uploader: "Dua Lipa", Duration: 300 * time.Second})
+
+	if official <= remix || official <= longer {
+		t.Fatalf("official %.2f, remix %.2f, longer %.2f", official, remix, longer)
+	}
+}
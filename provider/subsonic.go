@@ -0,0 +1,432 @@
+package Provider
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	Url "net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thirdscam/chatanium-musicbot/util"
+	"github.com/thirdscam/chatanium/src/Util/Log"
+)
+
+// The maximum time to wait for the server. (except streaming)
+const subsonicTimeout = 15 * time.Second
+
+// The version of the Subsonic REST API that the provider uses.
+const subsonicApiVersion = "1.16.1"
+
+// Subsonic plays the music of the self-hosted media server. (Subsonic REST API, e.g. Navidrome)
+//
+// Query:
+//   - "album:<name>" enqueues all songs of the album
+//   - "artist:<name>" enqueues all songs of the artist
+//   - "playlist:<name>" enqueues all songs of the playlist on the server
+//   - otherwise, searches the songs
+type Subsonic struct {
+	BaseUrl  string // e.g. https://music.example.com
+	User     string
+	Password string
+	Client   *http.Client
+
+	covers sync.Map // cover art ID of the songs (by MusicID)
+}
+
+// subsonicSong is a song (child) of the Subsonic API.
+type subsonicSong struct {
+	Id       string `json:"id"`
+	Title    string `json:"title"`
+	Artist   string `json:"artist"`
+	Album    string `json:"album"`
+	Duration int    `json:"duration"`
+	CoverArt string `json:"coverArt"`
+	IsDir    bool   `json:"isDir"`
+}
+
+// subsonicResponse is a part of the response of the Subsonic API that is used by the provider.
+type subsonicResponse struct {
+	Response struct {
+		Status string `json:"status"`
+		Error  struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+
+		SearchResult3 struct {
+			Song   []subsonicSong                      `json:"song"`
+			Album  []struct{ Id, Name, Artist string } `json:"album"`
+			Artist []struct{ Id, Name string }         `json:"artist"`
+		} `json:"searchResult3"`
+
+		Album struct {
+			Song []subsonicSong `json:"song"`
+		} `json:"album"`
+
+		Artist struct {
+			Album []struct{ Id, Name string } `json:"album"`
+		} `json:"artist"`
+
+		Playlists struct {
+			Playlist []struct {
+				Id        string `json:"id"`
+				Name      string `json:"name"`
+				SongCount int    `json:"songCount"`
+			} `json:"playlist"`
+		} `json:"playlists"`
+
+		Playlist struct {
+			Entry []subsonicSong `json:"entry"`
+		} `json:"playlist"`
+	} `json:"subsonic-response"`
+}
+
+func init() {
+	baseUrl := os.Getenv("MUSICBOT_SUBSONIC_URL")
+	if baseUrl == "" { // the media server is not configured
+		return
+	}
+
+	Register(Entry{
+		Name:         "subsonic",
+		Label:        "Media server",
+		Capabilities: CapabilitySearch | CapabilityPlaylist,
+		Provider: &Subsonic{
+			BaseUrl:  strings.TrimSuffix(baseUrl, "/"),
+			User:     os.Getenv("MUSICBOT_SUBSONIC_USER"),
+			Password: os.Getenv("MUSICBOT_SUBSONIC_PASSWORD"),
+			Client:   &http.Client{Timeout: subsonicTimeout},
+		},
+	})
+}
+
+func (s *Subsonic) Start(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, subsonicTimeout)
+	defer cancel()
+
+	if _, err := s.call(ctx, "ping", nil); err != nil {
+		Log.Error.Printf("[MusicBot] Failed to connect to the media server (%s): %v", s.BaseUrl, err)
+		return
+	}
+
+	Log.Info.Printf("[MusicBot] Media server connected: %s", s.BaseUrl)
+}
+
+func (s *Subsonic) GetMusic(ctx context.Context, query string) ([]Music, error) {
+	var songs []subsonicSong
+	var err error
+
+	switch {
+	case strings.HasPrefix(query, "album:"):
+		songs, err = s.album(ctx, strings.TrimSpace(strings.TrimPrefix(query, "album:")))
+
+	case strings.HasPrefix(query, "artist:"):
+		songs, err = s.artist(ctx, strings.TrimSpace(strings.TrimPrefix(query, "artist:")))
+
+	case strings.HasPrefix(query, "playlist:"):
+		songs, err = s.playlist(ctx, strings.TrimSpace(strings.TrimPrefix(query, "playlist:")))
+
+	default:
+		songs, err = s.search(ctx, query, 1)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(songs) == 0 {
+		return nil, fmt.Errorf("%w: no songs found on the media server (query: %s)", ErrNotFound, query)
+	}
+
+	return s.toMusic(songs), nil
+}
+
+func (s *Subsonic) Search(ctx context.Context, query string, limit int) ([]Music, error) {
+	songs, err := s.search(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(songs) == 0 {
+		return nil, fmt.Errorf("%w: no songs found on the media server (query: %s)", ErrNotFound, query)
+	}
+
+	return s.toMusic(songs), nil
+}
+
+// Resolve returns the stream URL of the song with the authentication.
+// (the token is salted per request, so it is not kept in the music)
+func (s *Subsonic) Resolve(ctx context.Context, music Music) (Music, error) {
+	u, err := Url.Parse(music.RawUrl)
+	if err != nil {
+		return Music{}, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+
+	music.RawUrl = s.url("stream", Url.Values{"id": {u.Query().Get("id")}})
+	return music, nil
+}
+
+// Artwork returns the cover art of the song.
+// (the URL of the cover art needs the authentication, so it cannot be used as a thumbnail URL)
+func (s *Subsonic) Artwork(ctx context.Context, music Music) ([]byte, error) {
+	coverArt, ok := s.covers.Load(music.Id)
+	if !ok || coverArt.(string) == "" {
+		return nil, fmt.Errorf("%w: no artwork", ErrNotFound)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url("getCoverArt", Url.Values{"id": {coverArt.(string)}, "size": {"300"}}), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, redactError(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 || !strings.HasPrefix(resp.Header.Get("Content-Type"), "image/") {
+		return nil, fmt.Errorf("%w: no artwork (HTTP %d)", ErrNotFound, resp.StatusCode)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, 8*1024*1024))
+}
+
+func (s *Subsonic) search(ctx context.Context, query string, limit int) ([]subsonicSong, error) {
+	r, err := s.call(ctx, "search3", Url.Values{
+		"query":       {query},
+		"songCount":   {fmt.Sprint(limit)},
+		"albumCount":  {"0"},
+		"artistCount": {"0"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Response.SearchResult3.Song, nil
+}
+
+// album returns the songs of the album that matches the name.
+func (s *Subsonic) album(ctx context.Context, name string) ([]subsonicSong, error) {
+	r, err := s.call(ctx, "search3", Url.Values{"query": {name}, "songCount": {"0"}, "albumCount": {"10"}, "artistCount": {"0"}})
+	if err != nil {
+		return nil, err
+	}
+
+	albums := r.Response.SearchResult3.Album
+	if len(albums) == 0 {
+		return nil, fmt.Errorf("%w: no album found on the media server (name: %s)", ErrNotFound, name)
+	}
+
+	// prefer the album with the exact name
+	id := albums[0].Id
+	for _, v := range albums {
+		if strings.EqualFold(v.Name, name) {
+			id = v.Id
+			break
+		}
+	}
+
+	return s.albumSongs(ctx, id)
+}
+
+// artist returns the songs of all albums of the artist that matches the name.
+func (s *Subsonic) artist(ctx context.Context, name string) ([]subsonicSong, error) {
+	r, err := s.call(ctx, "search3", Url.Values{"query": {name}, "songCount": {"0"}, "albumCount": {"0"}, "artistCount": {"10"}})
+	if err != nil {
+		return nil, err
+	}
+
+	artists := r.Response.SearchResult3.Artist
+	if len(artists) == 0 {
+		return nil, fmt.Errorf("%w: no artist found on the media server (name: %s)", ErrNotFound, name)
+	}
+
+	// prefer the artist with the exact name
+	id := artists[0].Id
+	for _, v := range artists {
+		if strings.EqualFold(v.Name, name) {
+			id = v.Id
+			break
+		}
+	}
+
+	r, err = s.call(ctx, "getArtist", Url.Values{"id": {id}})
+	if err != nil {
+		return nil, err
+	}
+
+	result := []subsonicSong{}
+	for _, v := range r.Response.Artist.Album {
+		songs, err := s.albumSongs(ctx, v.Id)
+		if err != nil {
+			Log.Verbose.Printf("[MusicBot] Failed to get album (%s): %v", v.Name, err)
+			continue
+		}
+		result = append(result, songs...)
+	}
+
+	return result, nil
+}
+
+// Playlists returns the playlists on the server. (suggested in the autocomplete of /play)
+func (s *Subsonic) Playlists(ctx context.Context) ([]Playlist, error) {
+	r, err := s.call(ctx, "getPlaylists", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []Playlist{}
+	for _, v := range r.Response.Playlists.Playlist {
+		result = append(result, Playlist{
+			Name:      v.Name,
+			Query:     "playlist:" + v.Name,
+			SongCount: v.SongCount,
+		})
+	}
+
+	return result, nil
+}
+
+// playlist returns the songs of the playlist on the server that matches the name.
+func (s *Subsonic) playlist(ctx context.Context, name string) ([]subsonicSong, error) {
+	r, err := s.call(ctx, "getPlaylists", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	id := ""
+	for _, v := range r.Response.Playlists.Playlist {
+		if strings.EqualFold(v.Name, name) {
+			id = v.Id
+			break
+		}
+		if id == "" && strings.Contains(strings.ToLower(v.Name), strings.ToLower(name)) {
+			id = v.Id
+		}
+	}
+
+	if id == "" {
+		return nil, fmt.Errorf("%w: no playlist found on the media server (name: %s)", ErrNotFound, name)
+	}
+
+	r, err = s.call(ctx, "getPlaylist", Url.Values{"id": {id}})
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Response.Playlist.Entry, nil
+}
+
+func (s *Subsonic) albumSongs(ctx context.Context, id string) ([]subsonicSong, error) {
+	r, err := s.call(ctx, "getAlbum", Url.Values{"id": {id}})
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Response.Album.Song, nil
+}
+
+// call calls the method of the Subsonic API.
+func (s *Subsonic) call(ctx context.Context, method string, params Url.Values) (subsonicResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url(method, params), nil)
+	if err != nil {
+		return subsonicResponse{}, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return subsonicResponse{}, ctx.Err()
+		}
+		return subsonicResponse{}, fmt.Errorf("%w: %v", ErrUnavailable, redactError(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return subsonicResponse{}, fmt.Errorf("%w: HTTP %d (method: %s)", ErrUnavailable, resp.StatusCode, method)
+	}
+
+	var result subsonicResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return subsonicResponse{}, fmt.Errorf("%w: invalid response: %v", ErrUnavailable, err)
+	}
+
+	if result.Response.Status != "ok" {
+		e := result.Response.Error
+		if e.Code == 70 { // the requested data was not found
+			return subsonicResponse{}, fmt.Errorf("%w: %s", ErrNotFound, e.Message)
+		}
+		return subsonicResponse{}, fmt.Errorf("%w: subsonic error %d: %s", ErrUnavailable, e.Code, e.Message)
+	}
+
+	return result, nil
+}
+
+// url returns the URL of the method with the authentication. (token = md5(password + salt))
+func (s *Subsonic) url(method string, params Url.Values) string {
+	salt := make([]byte, 8)
+	rand.Read(salt)
+	saltHex := hex.EncodeToString(salt)
+	token := md5.Sum([]byte(s.Password + saltHex))
+
+	query := Url.Values{}
+	for k, v := range params {
+		query[k] = v
+	}
+	query.Set("u", s.User)
+	query.Set("t", hex.EncodeToString(token[:]))
+	query.Set("s", saltHex)
+	query.Set("v", subsonicApiVersion)
+	query.Set("c", "chatanium-musicbot")
+	query.Set("f", "json")
+
+	return fmt.Sprintf("%s/rest/%s.view?%s", s.BaseUrl, method, query.Encode())
+}
+
+// redactError hides the credentials in the URL of the request error. (the error is logged and shown to the user)
+func redactError(err error) error {
+	var urlErr *Url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = util.RedactUrl(urlErr.URL)
+	}
+
+	return err
+}
+
+func (s *Subsonic) toMusic(songs []subsonicSong) []Music {
+	result := []Music{}
+	for _, v := range songs {
+		if v.IsDir || v.Id == "" {
+			continue
+		}
+
+		id := MusicID("SUBSONIC:" + util.GetSha256Hash(s.BaseUrl+":"+v.Id))
+		s.covers.Store(id, v.CoverArt)
+
+		title := v.Title
+		if v.Album != "" {
+			title = fmt.Sprintf("%s (%s)", v.Title, v.Album)
+		}
+
+		result = append(result, Music{
+			Id:       id,
+			Title:    title,
+			RawUrl:   fmt.Sprintf("%s/rest/stream.view?%s", s.BaseUrl, Url.Values{"id": {v.Id}}.Encode()), // without the authentication (see Resolve)
+			Type:     "subsonic",
+			Duration: time.Duration(v.Duration) * time.Second,
+			Uploader: v.Artist,
+		})
+	}
+
+	return result
+}
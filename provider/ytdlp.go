@@ -0,0 +1,529 @@
+package Provider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	Url "net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lrstanley/go-ytdlp"
+	"github.com/thirdscam/chatanium-musicbot/util"
+	"github.com/thirdscam/chatanium/src/Util/Log"
+)
+
+// Extractor plays the music of the sites supported by yt-dlp.
+//
+// The query is a URL of the site, or a keyword if the extractor has a search prefix.
+// the sites can be limited by the operator with MUSICBOT_YTDLP_ALLOW and MUSICBOT_YTDLP_DENY. (see SitePolicy)
+type Extractor struct {
+	Name         string // name of the provider (also used as Music.Type)
+	IdPrefix     string // prefix of the MusicID (e.g. "YT")
+	SearchPrefix string // search prefix of yt-dlp (e.g. "ytsearch", "scsearch"). empty if the extractor cannot search
+	MultiSite    bool   // the extractor handles many sites (the ID of the music is qualified by the site)
+
+	Sites func(url string) bool // the URLs of the sites that the extractor plays (nil if any site)
+}
+
+// SitePolicy is the allow/deny list of the sites (hosts) that the yt-dlp providers can play.
+//
+// a host matches the list if it is the same as, or a subdomain of, an entry of the list.
+// (e.g. "soundcloud.com" matches "m.soundcloud.com")
+type SitePolicy struct {
+	Allow []string // if not empty, only these sites are allowed
+	Deny  []string // these sites are never allowed
+}
+
+var isSoundcloudUrl = matchHosts("soundcloud.com", "on.soundcloud.com")
+
+// The site policy of the yt-dlp providers. (configured by the operator)
+var sitePolicy = SitePolicy{
+	Allow: splitList(os.Getenv("MUSICBOT_YTDLP_ALLOW")),
+	Deny:  splitList(os.Getenv("MUSICBOT_YTDLP_DENY")),
+}
+
+func init() {
+	Register(Entry{
+		Name:         "soundcloud",
+		Label:        "SoundCloud",
+		Capabilities: CapabilitySearch | CapabilityUrl | CapabilityPlaylist,
+		Provider:     &Extractor{Name: "soundcloud", IdPrefix: "SC", SearchPrefix: "scsearch", Sites: isSoundcloudUrl},
+		Match:        isSoundcloudUrl,
+	})
+
+	// claims every URL that no other provider claims (yt-dlp supports hundreds of sites)
+	Register(Entry{
+		Name:         "ytdlp",
+		Label:        "Other sites (yt-dlp)",
+		Capabilities: CapabilityUrl | CapabilityPlaylist,
+		Provider:     &Extractor{Name: "ytdlp", IdPrefix: "YTDLP", MultiSite: true},
+		Match:        util.IsUrl,
+		Fallback:     true,
+	})
+}
+
+var startYtdlpOnce sync.Once
+
+func (x *Extractor) Start(ctx context.Context) {
+	// yt-dlp is shared by the providers, so install and update it only once.
+	startYtdlpOnce.Do(func() {
+		startYtdlp(ctx)
+	})
+}
+
+func (x *Extractor) GetMusic(ctx context.Context, query string) ([]Music, error) {
+	// check if the query is a playlist or music URL
+	if util.IsUrl(query) {
+		Log.Verbose.Printf("[MusicBot] Query is a URL (%s): %s", x.Name, query)
+		if x.Sites != nil && !x.Sites(query) {
+			return nil, fmt.Errorf("%w: %s cannot play the URL (query: %s)", ErrUnsupported, x.Name, query)
+		}
+		if err := sitePolicy.check(query); err != nil {
+			return nil, err
+		}
+		return x.getUrl(ctx, query)
+	}
+
+	// else, search for the query
+	Log.Verbose.Printf("[MusicBot] Query is a search (%s): %s", x.Name, query)
+	return x.getSearch(ctx, query)
+}
+
+func (x *Extractor) Search(ctx context.Context, query string, limit int) ([]Music, error) {
+	if x.SearchPrefix == "" {
+		return nil, fmt.Errorf("%w: %s cannot search (query: %s)", ErrUnsupported, x.Name, query)
+	}
+
+	Log.Verbose.Printf("[MusicBot] Search candidates (%s, %d): %s", x.Name, limit, query)
+
+	// the candidates are not played until selected, so the media URL is not needed. (much faster)
+	result, err := x.run(ctx, fmt.Sprintf("%s%d:%s", x.SearchPrefix, limit, query), "--flat-playlist")
+	if len(result) == 0 {
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: no results found (query: %s)", ErrNotFound, query)
+	}
+
+	return result, nil
+}
+
+func (x *Extractor) Resolve(ctx context.Context, music Music) (Music, error) {
+	if music.WebpageUrl == "" {
+		return music, nil
+	}
+
+	result, err := x.run(ctx, music.WebpageUrl, "--no-playlist")
+	if len(result) == 0 {
+		if err != nil {
+			return Music{}, err
+		}
+		return Music{}, fmt.Errorf("%w: cannot resolve media url (url: %s)", ErrNotFound, music.WebpageUrl)
+	}
+
+	// the entries of the flat playlist (or search) have no chapters and live status, so take them from the full entry.
+	music.RawUrl = result[0].RawUrl
+	music.IsLive = result[0].IsLive
+	if result[0].Duration != 0 {
+		music.Duration = result[0].Duration
+	}
+	if len(music.Chapters) == 0 {
+		music.Chapters = result[0].Chapters
+	}
+
+	return music, nil
+}
+
+func (x *Extractor) getSearch(ctx context.Context, query string) ([]Music, error) {
+	if x.SearchPrefix == "" {
+		return nil, fmt.Errorf("%w: %s cannot search (query: %s)", ErrUnsupported, x.Name, query)
+	}
+
+	result, err := x.run(ctx, x.SearchPrefix+"1:"+query)
+	if len(result) == 0 {
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("%w: no results found (query: %s)", ErrNotFound, query)
+	}
+
+	return result, nil
+}
+
+func (x *Extractor) getUrl(ctx context.Context, url string) ([]Music, error) {
+	// the entries of the playlist are not extracted (only IDs and titles), and their media URLs are resolved later. (much faster)
+	// if some entries of the playlist are broken, the rest of the result is still valid. (err is *PartialError)
+	result, err := x.run(ctx, youtubeChannelVideos(url), "--flat-playlist")
+	if len(result) == 0 {
+		if err != nil {
+			return nil, err
+		}
+
+		Log.Verbose.Println("[MusicBot] cannot find result")
+		return nil, fmt.Errorf("%w: no results found (url: %s)", ErrNotFound, url)
+	}
+
+	return result, err
+}
+
+// The URL of the home of a YouTube channel. (e.g. https://www.youtube.com/@name)
+var youtubeChannelHome = regexp.MustCompile(`^(https?://(?:www\.|m\.)?youtube\.com/(?:@[^/?#]+|channel/[^/?#]+|c/[^/?#]+|user/[^/?#]+))/?(\?.*)?$`)
+
+// youtubeChannelVideos returns the URL of the videos tab if the URL is the home of a YouTube channel.
+//
+// The flat extraction of the home returns the tabs (videos, shorts, live) instead of the videos.
+func youtubeChannelVideos(url string) string {
+	match := youtubeChannelHome.FindStringSubmatch(url)
+	if match == nil {
+		return url
+	}
+
+	return match[1] + "/videos" + match[2]
+}
+
+// startYtdlp installs yt-dlp and keeps it up to date.
+func startYtdlp(ctx context.Context) {
+	ytdlp.MustInstall(ctx, nil)
+	Log.Info.Println("[MusicBot] yt-dlp installed, starting...")
+
+	beforeVersion, err := exec.CommandContext(ctx, util.GetYtdlpPath(), "--version", "--quiet", "--no-warnings").Output()
+	if err != nil {
+		Log.Error.Printf("[MusicBot] Failed to get yt-dlp version: %v", err)
+	}
+
+	err = exec.CommandContext(ctx, util.GetYtdlpPath(), "-U", "--quiet", "--no-warnings").Run()
+	if err != nil {
+		Log.Error.Printf("[MusicBot] Failed to update yt-dlp: %v", err)
+	}
+
+	atferVersion, err := exec.CommandContext(ctx, util.GetYtdlpPath(), "--version", "--quiet", "--no-warnings").Output()
+	if err != nil {
+		Log.Error.Printf("[MusicBot] Failed to get yt-dlp version: %v", err)
+	}
+
+	if string(beforeVersion) == string(atferVersion) {
+		Log.Info.Printf("[MusicBot] yt-dlp is already up to date. (v.%s)", strings.TrimSuffix(string(beforeVersion), "\n"))
+	} else {
+		Log.Info.Printf("[MusicBot] yt-dlp updated: %s => %s", strings.TrimSuffix(string(beforeVersion), "\n"), strings.TrimSuffix(string(atferVersion), "\n"))
+	}
+
+	go func() {
+		for {
+			// update yt-dlp every 12 hours (until the provider is stopped)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(12 * time.Hour):
+			}
+			err = exec.CommandContext(ctx, util.GetYtdlpPath(), "-U", "--quiet", "--no-warnings").Run()
+			if err != nil {
+				Log.Error.Printf("[MusicBot] Failed to update yt-dlp: %v", err)
+			}
+
+			atferVersion, err = exec.CommandContext(ctx, util.GetYtdlpPath(), "--version", "--quiet", "--no-warnings").Output()
+			if err != nil {
+				Log.Error.Printf("[MusicBot] Failed to get yt-dlp version: %v", err)
+			}
+
+			if string(beforeVersion) == string(atferVersion) {
+				Log.Info.Printf("[MusicBot] yt-dlp auto-updated: %s => %s", strings.TrimSuffix(string(beforeVersion), "\n"), strings.TrimSuffix(string(atferVersion), "\n"))
+				beforeVersion = atferVersion // overwrite the version
+			} else {
+				Log.Verbose.Printf("[MusicBot] yt-dlp is already up to date. (v.%s)", strings.TrimSuffix(string(atferVersion), "\n"))
+			}
+		}
+	}()
+}
+
+// ytdlpEntry is a part of the JSON output of yt-dlp (--dump-json) that is used by the provider.
+type ytdlpEntry struct {
+	Type         string  `json:"_type"` // "url" if the entry is not extracted (--flat-playlist)
+	Id           string  `json:"id"`
+	ExtractorKey string  `json:"extractor_key"`
+	IeKey        string  `json:"ie_key"` // the extractor of the flat entry (instead of extractor_key)
+	Title        string  `json:"title"`
+	Url          string  `json:"url"`
+	Thumbnail    string  `json:"thumbnail"`
+	Duration     float64 `json:"duration"`
+	Uploader     string  `json:"uploader"`
+	Channel      string  `json:"channel"`
+	WebpageUrl   string  `json:"webpage_url"`
+	Thumbnails   []struct {
+		Url string `json:"url"`
+	} `json:"thumbnails"`
+	IsLive     bool   `json:"is_live"`
+	LiveStatus string `json:"live_status"`
+	Chapters   []struct {
+		Title     string  `json:"title"`
+		StartTime float64 `json:"start_time"`
+		EndTime   float64 `json:"end_time"`
+	} `json:"chapters"`
+}
+
+// ytdlpErrorLine matches the error of each entry. (e.g. "ERROR: [youtube] dQw4w9WgXcQ: Video unavailable")
+var ytdlpErrorLine = regexp.MustCompile(`^ERROR: (?:\[[^\]]+\] )?(?:([\w-]+): )?(.*)$`)
+
+// run runs yt-dlp with the target (URL or search keyword) and parses its JSON output.
+// args are appended to the default arguments.
+//
+// The broken entries of the target are skipped, and reported as *PartialError with the rest of the result.
+func (x *Extractor) run(ctx context.Context, target string, args ...string) ([]Music, error) {
+	var stdout, stderr bytes.Buffer
+
+	args = append([]string{target, "--dump-json", "--ignore-errors", "--no-warnings", "--skip-download", "--format=bestaudio/best"}, args...)
+	cmd := exec.CommandContext(ctx, util.GetYtdlpPath(), args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+
+	// if the process is killed by the context, report the reason of the context.
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	// 1. parse the entries (one JSON object per line)
+	result := []Music{}
+	partial := &PartialError{}
+
+	scanner := bufio.NewScanner(&stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024) // a single entry can be a few MB (formats, subtitles, etc.)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var entry ytdlpEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			partial.Skipped = append(partial.Skipped, SkippedEntry{Reason: fmt.Errorf("invalid yt-dlp output: %w", err)})
+			continue
+		}
+
+		music, err := x.toMusic(entry)
+		if err != nil {
+			partial.Skipped = append(partial.Skipped, SkippedEntry{Id: entry.Id, Title: entry.Title, Reason: err})
+			continue
+		}
+
+		result = append(result, music)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read yt-dlp output: %w", err)
+	}
+
+	// 2. collect the errors of the broken entries
+	for _, line := range strings.Split(stderr.String(), "\n") {
+		match := ytdlpErrorLine.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+
+		partial.Skipped = append(partial.Skipped, SkippedEntry{Id: match[1], Reason: classifyYtdlpError(match[2])})
+	}
+
+	// if nothing is parsed, the whole query is failed.
+	if len(result) == 0 && runErr != nil {
+		return nil, ytdlpError(ctx, runErr, stderr.String())
+	}
+
+	if len(partial.Skipped) > 0 {
+		for _, v := range partial.Skipped {
+			Log.Verbose.Printf("[MusicBot] Skipped entry (%s): %v", v.Id, v.Reason)
+		}
+
+		// if every entry is broken, report the reason of the first one.
+		if len(result) == 0 {
+			return nil, partial.Skipped[0].Reason
+		}
+		return result, partial
+	}
+
+	return result, nil
+}
+
+// toMusic converts the entry into the music.
+func (x *Extractor) toMusic(e ytdlpEntry) (Music, error) {
+	if e.Id == "" {
+		return Music{}, fmt.Errorf("%w: entry has no id", ErrUnavailable)
+	}
+
+	if !util.IsUrl(e.Url) {
+		return Music{}, fmt.Errorf("%w: entry has no playable url", ErrUnavailable)
+	}
+
+	// the ID of the entry is unique only in its site, so qualify it if the extractor handles many sites.
+	id := e.Id
+	if x.MultiSite {
+		extractor := e.ExtractorKey
+		if extractor == "" {
+			extractor = e.IeKey
+		}
+		id = extractor + ":" + e.Id
+	}
+
+	uploader := e.Uploader
+	if uploader == "" {
+		uploader = e.Channel
+	}
+
+	// the flat entry only has the URL of the page, so the media URL is resolved later. (see Resolve())
+	rawUrl, webpageUrl := e.Url, e.WebpageUrl
+	if e.Type == "url" {
+		rawUrl, webpageUrl = "", e.Url
+	}
+
+	// the flat entry has no representative thumbnail, so use the last one. (the largest)
+	thumbnail := e.Thumbnail
+	if thumbnail == "" && len(e.Thumbnails) > 0 {
+		thumbnail = e.Thumbnails[len(e.Thumbnails)-1].Url
+	}
+
+	chapters := []Chapter{}
+	for _, v := range e.Chapters {
+		chapters = append(chapters, Chapter{
+			Title: v.Title,
+			Start: seconds(v.StartTime),
+			End:   seconds(v.EndTime),
+		})
+	}
+
+	return Music{
+		Id:           MusicID(x.IdPrefix + ":" + util.GetSha256Hash(id)),
+		Title:        e.Title,
+		RawUrl:       rawUrl,
+		ThumbnailUrl: thumbnail,
+		Type:         x.Name,
+		Duration:     seconds(e.Duration),
+		Uploader:     uploader,
+		WebpageUrl:   webpageUrl,
+		IsLive:       e.IsLive || e.LiveStatus == "is_live",
+		Chapters:     chapters,
+	}, nil
+}
+
+// seconds converts the seconds (yt-dlp format) into time.Duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// ytdlpError converts the error of the yt-dlp process into the provider errors.
+func ytdlpError(ctx context.Context, err error, stderr string) error {
+	// if the process is killed by the context, report the reason of the context.
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+
+	return classifyYtdlpError(strings.TrimSpace(stderr))
+}
+
+// classifyYtdlpError converts the error message of yt-dlp into the provider errors.
+func classifyYtdlpError(stderr string) error {
+	message := strings.ToLower(stderr)
+
+	switch {
+	case strings.Contains(message, "sign in to confirm your age"),
+		strings.Contains(message, "age-restricted"),
+		strings.Contains(message, "inappropriate for some users"):
+		return fmt.Errorf("%w: %s", ErrAgeRestricted, stderr)
+
+	case strings.Contains(message, "not available in your country"),
+		strings.Contains(message, "not made this video available in your country"),
+		strings.Contains(message, "geo restricted"),
+		strings.Contains(message, "geo-restricted"):
+		return fmt.Errorf("%w: %s", ErrGeoBlocked, stderr)
+
+	case strings.Contains(message, "http error 429"),
+		strings.Contains(message, "too many requests"),
+		strings.Contains(message, "confirm you're not a bot"),
+		strings.Contains(message, "confirm you’re not a bot"):
+		return fmt.Errorf("%w: %s", ErrRateLimited, stderr)
+
+	case strings.Contains(message, "private playlist"),
+		strings.Contains(message, "playlist does not exist"),
+		strings.Contains(message, "this playlist is private"):
+		return fmt.Errorf("%w: %s", ErrPrivatePlaylist, stderr)
+
+	case strings.Contains(message, "private video"),
+		strings.Contains(message, "video unavailable"),
+		strings.Contains(message, "has been removed"),
+		strings.Contains(message, "members-only"),
+		strings.Contains(message, "premieres in"):
+		return fmt.Errorf("%w: %s", ErrUnavailable, stderr)
+
+	case strings.Contains(message, "unsupported url"):
+		return fmt.Errorf("%w: %s", ErrUnsupported, stderr)
+
+	case strings.Contains(message, "incomplete youtube id"),
+		strings.Contains(message, "http error 404"):
+		return fmt.Errorf("%w: %s", ErrNotFound, stderr)
+	}
+
+	return fmt.Errorf("yt-dlp failed: %s", stderr)
+}
+
+// check reports whether the site of the URL is allowed.
+func (p SitePolicy) check(url string) error {
+	u, err := Url.Parse(url)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return fmt.Errorf("%w: no host (url: %s)", ErrUnsupported, url)
+	}
+	if matchHost(host, p.Deny) || (len(p.Allow) > 0 && !matchHost(host, p.Allow)) {
+		return fmt.Errorf("%w: %s", ErrNotAllowed, host)
+	}
+
+	return nil
+}
+
+// matchHost reports whether the host is the same as, or a subdomain of, an entry of the list.
+func matchHost(host string, list []string) bool {
+	for _, v := range list {
+		if host == v || strings.HasSuffix(host, "."+v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchHosts returns the Match function of the provider that claims the URLs of the hosts.
+func matchHosts(hosts ...string) func(url string) bool {
+	return func(url string) bool {
+		u, err := Url.ParseRequestURI(url)
+		if err != nil {
+			return false
+		}
+
+		return matchHost(strings.ToLower(u.Hostname()), hosts)
+	}
+}
+
+// splitList splits the comma separated list. (empty entries are ignored)
+func splitList(s string) []string {
+	result := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.ToLower(strings.TrimSpace(v)); v != "" {
+			result = append(result, v)
+		}
+	}
+
+	return result
+}
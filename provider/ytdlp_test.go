@@ -0,0 +1,32 @@
+package Provider
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestExtractorRejectsUrl(t *testing.T) {
+	youtube := &Extractor{Name: "youtube", IdPrefix: "YT", SearchPrefix: "ytsearch", Sites: matchHosts("youtube.com")}
+	ytdlp := &Extractor{Name: "ytdlp", IdPrefix: "YTDLP", MultiSite: true}
+
+	// rejected before yt-dlp is called
+	tests := []struct {
+		extractor *Extractor
+		query     string
+		want      error
+	}{
+		{youtube, "https://soundcloud.com/artist/track", ErrUnsupported},
+		{ytdlp, "file:///etc/passwd", ErrUnsupported},
+	}
+
+	for _, tt := range tests {
+		if _, err := tt.extractor.GetMusic(context.Background(), tt.query); !errors.Is(err, tt.want) {
+			t.Errorf("%s: %s: got %v, want %v", tt.extractor.Name, tt.query, err, tt.want)
+		}
+	}
+
+	if err := sitePolicy.check("/etc/passwd"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("site policy of a bare path: got %v, want ErrUnsupported", err)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	Provider "github.com/thirdscam/chatanium-musicbot/provider"
+)
+
+// The interval to check the new data of the file being encoded.
+const GROWING_POLL_INTERVAL = 50 * time.Millisecond
+
+// encoding is the progress of the music being encoded into its file.
+type encoding struct {
+	done chan struct{} // closed when the encoding is finished
+	err  error         // the error of the encoding (nil if the file is complete)
+	once sync.Once
+}
+
+// The music being encoded (by MusicID)
+var (
+	encodingsMu sync.Mutex
+	encodings   = map[Provider.MusicID]*encoding{}
+)
+
+// startEncoding marks the music as being encoded. (the encoder must call finish() when it ends)
+func startEncoding(musicId Provider.MusicID) *encoding {
+	enc := &encoding{done: make(chan struct{})}
+
+	encodingsMu.Lock()
+	encodings[musicId] = enc
+	encodingsMu.Unlock()
+
+	go func() {
+		<-enc.done
+		encodingsMu.Lock()
+		if encodings[musicId] == enc {
+			delete(encodings, musicId)
+		}
+		encodingsMu.Unlock()
+	}()
+
+	return enc
+}
+
+// finish reports the end of the encoding. (only the first call is effective)
+func (e *encoding) finish(err error) {
+	e.once.Do(func() {
+		e.err = err
+		close(e.done)
+	})
+}
+
+// waitEncoding waits until the encoding of the music is finished. (returns at once if it is not being encoded)
+func waitEncoding(musicId Provider.MusicID) {
+	encodingsMu.Lock()
+	enc := encodings[musicId]
+	encodingsMu.Unlock()
+
+	if enc != nil {
+		<-enc.done
+	}
+}
+
+// openMusic opens the music file to play.
+//
+// if the music is still being encoded, the reader waits for the encoder instead of reporting EOF,
+// and reports the error of the encoding when the encoding is failed.
+func openMusic(musicId Provider.MusicID) (io.ReadCloser, error) {
+	encodingsMu.Lock()
+	enc := encodings[musicId]
+	encodingsMu.Unlock()
+
+	if enc == nil { // the file is complete
+		return os.Open(getMusicPath(musicId))
+	}
+
+	// the partial file is renamed when the encoding is finished, so the file may be complete already
+	file, err := os.Open(getPartialPath(musicId))
+	if os.IsNotExist(err) {
+		return os.Open(getMusicPath(musicId))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &growingReader{file: file, enc: enc}, nil
+}
+
+// growingReader reads the file that is being written by the encoder.
+type growingReader struct {
+	file *os.File
+	enc  *encoding
+}
+
+func (r *growingReader) Read(p []byte) (int, error) {
+	for {
+		n, err := r.file.Read(p)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+
+		// reached the end of the written data, so wait for the encoder
+		select {
+		case <-r.enc.done:
+			// read the rest of the data written before the end
+			n, err := r.file.Read(p)
+			if n > 0 || err != io.EOF {
+				return n, err
+			}
+
+			if r.enc.err != nil {
+				return 0, r.enc.err
+			}
+			return 0, io.EOF
+
+		case <-time.After(GROWING_POLL_INTERVAL):
+		}
+	}
+}
+
+func (r *growingReader) Close() error {
+	return r.file.Close()
+}
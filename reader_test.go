@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGrowingReader(t *testing.T) {
+	errEncode := errors.New("encode failed")
+
+	tests := []struct {
+		name string
+		err  error // the error of the encoding
+	}{
+		{"complete", nil},
+		{"failed", errEncode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "music.part")
+			w, err := os.Create(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			enc := &encoding{done: make(chan struct{})}
+			r := &growingReader{file: file, enc: enc}
+			defer r.Close()
+
+			// the encoder writes the rest while the reader waits at the end of the file
+			w.WriteString("first")
+			go func() {
+				time.Sleep(2 * GROWING_POLL_INTERVAL)
+				w.WriteString("second")
+				time.Sleep(2 * GROWING_POLL_INTERVAL)
+				w.WriteString("last")
+				enc.finish(tt.err)
+			}()
+
+			data, err := io.ReadAll(r)
+			if string(data) != "firstsecondlast" {
+				t.Errorf("read %q, want all of the written data", data)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCompleteFile(t *testing.T) {
+	errEncode := errors.New("encode failed")
+
+	tests := []struct {
+		name       string
+		err        error
+		isComplete bool
+	}{
+		{"complete", nil, true},
+		{"failed", errEncode, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "music")
+			partialPath := path + PARTIAL_SUFFIX
+			os.WriteFile(partialPath, []byte("opus"), 0o644)
+
+			if err := completeFile(partialPath, path, tt.err); !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+
+			if _, err := os.Stat(partialPath); !os.IsNotExist(err) {
+				t.Error("the partial file is left")
+			}
+			if _, err := os.Stat(path); (err == nil) != tt.isComplete {
+				t.Errorf("the file exists %v, want %v", err == nil, tt.isComplete)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	Provider "github.com/thirdscam/chatanium-musicbot/provider"
+	"github.com/thirdscam/chatanium/src/Util/Log"
+)
+
+// The number of downloads to run at the same time, until their encoding is finished. (MUSICBOT_DOWNLOAD_WORKERS overrides it)
+var DOWNLOAD_WORKERS = 2
+
+// The number of downloads per minute for each provider. (MUSICBOT_DOWNLOAD_RATE overrides it)
+var DOWNLOAD_RATE = 6.0
+
+// The number of downloads that a provider can start at once after being idle.
+const DOWNLOAD_BURST = 3
+
+// The priority of the music that the player is waiting for. (the position in the queue is used for the others)
+const PRIORITY_PLAYING = 0
+
+var scheduler *downloadScheduler
+
+func init() {
+	if v, err := strconv.Atoi(os.Getenv("MUSICBOT_DOWNLOAD_WORKERS")); err == nil && v > 0 {
+		DOWNLOAD_WORKERS = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("MUSICBOT_DOWNLOAD_RATE"), 64); err == nil && v > 0 {
+		DOWNLOAD_RATE = v
+	}
+
+	scheduler = newDownloadScheduler(DOWNLOAD_WORKERS)
+}
+
+// downloadRequest is a download waiting for a worker.
+type downloadRequest struct {
+	ctx      context.Context
+	music    Provider.Music
+	priority int    // lower is first
+	seq      uint64 // order of the request (first come, first served in the same priority)
+	result   chan error
+	isShared bool // the music is cached or being downloaded by another request (no token is taken)
+}
+
+// downloadScheduler runs the downloads of all channels with the shared workers.
+//
+// The waiting downloads are started in the order of the priority,
+// and the downloads of each provider are limited by its token bucket. (to avoid the rate limit of the provider)
+// a worker is busy until the encoding of its download is finished, not only until the music is ready to play.
+//
+// One more worker is reserved for the music that the players are waiting for. (PRIORITY_PLAYING)
+// it is free again when the music is ready to play, so the long encodings of the prefetch never hold the playback.
+type downloadScheduler struct {
+	mu           sync.Mutex
+	pending      []*downloadRequest // sorted by priority and seq
+	buckets      map[string]*tokenBucket
+	seq          uint64
+	wake         chan struct{}
+	wakeReserved chan struct{} // wakes up the reserved worker
+}
+
+func newDownloadScheduler(workers int) *downloadScheduler {
+	d := &downloadScheduler{
+		buckets:      map[string]*tokenBucket{},
+		wake:         make(chan struct{}, 1),
+		wakeReserved: make(chan struct{}, 1),
+	}
+
+	for range workers {
+		go d.worker(false)
+	}
+	go d.worker(true)
+
+	return d
+}
+
+// Download downloads the music by a worker of the scheduler, and waits for the result.
+// (the lower priority is started first)
+func (d *downloadScheduler) Download(ctx context.Context, music Provider.Music, priority int) error {
+	req := d.push(ctx, music, priority)
+	d.notify()
+
+	select {
+	case err := <-req.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err() // the worker drops the request
+	}
+}
+
+// push adds the request of the download in the order of the priority.
+func (d *downloadScheduler) push(ctx context.Context, music Provider.Music, priority int) *downloadRequest {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.seq++
+	req := &downloadRequest{ctx: ctx, music: music, priority: priority, seq: d.seq, result: make(chan error, 1)}
+
+	// insert the request in order
+	index := sort.Search(len(d.pending), func(j int) bool {
+		v := d.pending[j]
+		return v.priority > priority || (v.priority == priority && v.seq > req.seq)
+	})
+	d.pending = append(d.pending[:index], append([]*downloadRequest{req}, d.pending[index:]...)...)
+
+	return req
+}
+
+// notify wakes up the waiting workers.
+func (d *downloadScheduler) notify() {
+	for _, wake := range []chan struct{}{d.wake, d.wakeReserved} {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// worker runs the downloads. (the reserved worker runs only the music that the players are waiting for)
+func (d *downloadScheduler) worker(isReserved bool) {
+	wake := d.wake
+	if isReserved {
+		wake = d.wakeReserved
+	}
+
+	for {
+		req, wait := d.next(isReserved)
+		if req == nil {
+			if wait > 0 { // the providers of the requests are limited
+				select {
+				case <-wake:
+				case <-time.After(wait):
+				}
+			} else {
+				<-wake
+			}
+			continue
+		}
+
+		if !req.isShared {
+			Log.Verbose.Printf("[MusicBot] Starting download (priority: %d): %s", req.priority, req.music.Title)
+		}
+		req.result <- DownloadMusic(req.ctx, req.music)
+
+		// the requester plays the music while it is encoded, but the worker waits for the end of the encoding
+		// (the reserved worker is free for the next playback at once)
+		if !req.isShared && !isReserved {
+			waitEncoding(req.music.Id)
+		}
+	}
+}
+
+// next takes the first request whose provider is not limited. (only PRIORITY_PLAYING if isReserved)
+// if there is no such request, it returns the time to wait for the next token. (0 if no request)
+func (d *downloadScheduler) next(isReserved bool) (*downloadRequest, time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	wait := time.Duration(0)
+	for j := 0; j < len(d.pending); j++ {
+		req := d.pending[j]
+		if isReserved && req.priority > PRIORITY_PLAYING { // the rest has the lower priority
+			break
+		}
+
+		// the canceled request is dropped
+		if req.ctx.Err() != nil {
+			req.result <- req.ctx.Err()
+			d.pending = append(d.pending[:j], d.pending[j+1:]...)
+			j--
+			continue
+		}
+
+		// the cached music does not spend the token of the provider
+		if !isDownloadNeeded(req.music.Id) {
+			req.isShared = true
+			d.pending = append(d.pending[:j], d.pending[j+1:]...)
+			return req, 0
+		}
+
+		ok, after := d.bucket(req.music.Type).take(now)
+		if !ok {
+			if wait == 0 || after < wait {
+				wait = after
+			}
+			continue
+		}
+
+		d.pending = append(d.pending[:j], d.pending[j+1:]...)
+		if len(d.pending) > 0 {
+			d.notify() // the other workers can take the rest
+		}
+		return req, 0
+	}
+
+	return nil, wait
+}
+
+func (d *downloadScheduler) bucket(provider string) *tokenBucket {
+	if b, ok := d.buckets[provider]; ok {
+		return b
+	}
+
+	b := &tokenBucket{rate: DOWNLOAD_RATE / 60, burst: DOWNLOAD_BURST, tokens: DOWNLOAD_BURST, last: time.Now()}
+	d.buckets[provider] = b
+	return b
+}
+
+// tokenBucket limits the rate of the downloads. (refilled by rate tokens per second, up to burst)
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// take takes a token if available.
+// if not, it returns the time to wait for the next token.
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
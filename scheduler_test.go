@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	Provider "github.com/thirdscam/chatanium-musicbot/provider"
+)
+
+// newTestScheduler returns the scheduler without the workers. (the test takes the requests by next)
+func newTestScheduler() *downloadScheduler {
+	return &downloadScheduler{
+		buckets:      map[string]*tokenBucket{},
+		wake:         make(chan struct{}, 1),
+		wakeReserved: make(chan struct{}, 1),
+	}
+}
+
+func TestSchedulerOrder(t *testing.T) {
+	useMusicPath(t, 1<<30, time.Hour) // no music is cached
+	d := newTestScheduler()
+
+	ctx := context.Background()
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	d.push(ctx, Provider.Music{Id: "TEST:ahead2", Type: "a"}, 2)
+	d.push(ctx, Provider.Music{Id: "TEST:ahead1", Type: "a"}, 1)
+	dropped := d.push(canceled, Provider.Music{Id: "TEST:canceled", Type: "a"}, PRIORITY_PLAYING)
+	d.push(ctx, Provider.Music{Id: "TEST:playing1", Type: "a"}, PRIORITY_PLAYING)
+	d.push(ctx, Provider.Music{Id: "TEST:playing2", Type: "b"}, PRIORITY_PLAYING)
+
+	tests := []struct {
+		isReserved bool
+		want       Provider.MusicID // empty if no request is taken
+	}{
+		{false, "TEST:playing1"},
+		{true, "TEST:playing2"},
+		{true, ""}, // the reserved worker takes only the music that the players are waiting for
+		{false, "TEST:ahead1"},
+		{false, "TEST:ahead2"},
+	}
+
+	for j, tt := range tests {
+		req, _ := d.next(tt.isReserved)
+		got := Provider.MusicID("")
+		if req != nil {
+			got = req.music.Id
+		}
+		if got != tt.want {
+			t.Errorf("#%d: got %q, want %q", j, got, tt.want)
+		}
+	}
+
+	select {
+	case err := <-dropped.result:
+		if err != context.Canceled {
+			t.Errorf("the canceled request got %v", err)
+		}
+	default:
+		t.Error("the canceled request is not dropped")
+	}
+}
+
+func TestSchedulerRateLimit(t *testing.T) {
+	useMusicPath(t, 1<<30, time.Hour)
+	d := newTestScheduler()
+
+	ctx := context.Background()
+	for range DOWNLOAD_BURST {
+		d.push(ctx, Provider.Music{Id: "TEST:limited", Type: "a"}, 1)
+	}
+	d.push(ctx, Provider.Music{Id: "TEST:limited", Type: "a"}, 1)
+	d.push(ctx, Provider.Music{Id: "TEST:other", Type: "b"}, 2)
+
+	for range DOWNLOAD_BURST {
+		if req, _ := d.next(false); req == nil || req.music.Type != "a" {
+			t.Fatal("the burst of the provider is not taken first")
+		}
+	}
+
+	// the limited provider does not hold the requests of the other provider
+	req, _ := d.next(false)
+	if req == nil || req.music.Type != "b" {
+		t.Fatal("the request of the other provider is not taken")
+	}
+
+	req, wait := d.next(false)
+	if req != nil || wait <= 0 {
+		t.Fatalf("got %v (wait %v), want to wait for the next token", req, wait)
+	}
+}
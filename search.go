@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	Provider "github.com/thirdscam/chatanium-musicbot/provider"
+	"github.com/thirdscam/chatanium-musicbot/util"
+	"github.com/thirdscam/chatanium/src/Util/Log"
+)
+
+// The number of candidates to show when the count is not given.
+const SEARCH_LIMIT = 5
+
+// The time to keep the search results for the selection.
+const SEARCH_EXPIRE = 10 * time.Minute
+
+// The search results waiting for the selection of the user (by interaction ID)
+var (
+	searchResultsMu sync.Mutex
+	searchResults   map[string][]Provider.Music = make(map[string][]Provider.Music)
+)
+
+func Search(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	bindComponents(s)
+
+	options := util.GetOptions(i)
+	query := options["query"].StringValue()
+
+	Log.Verbose.Printf("[MusicBot] Search command called by %s (C:%s, %s)", i.Member.User.Username, i.ChannelID, query)
+	util.EphemeralResponse(s, i, "**Searching...**")
+
+	// Get the provider (already started in Start())
+	providerName := ""
+	if v, ok := options["provider"]; ok {
+		providerName = v.StringValue()
+	}
+
+	provider, ok := selectProvider(i.GuildID, providerName, query)
+	if !ok {
+		util.EditResponse(s, i, "**Invalid provider name!**\nPlease input a valid provider name.")
+		return
+	}
+
+	limit := SEARCH_LIMIT
+	if v, ok := options["count"]; ok {
+		limit = int(v.IntValue())
+	}
+
+	// Get the candidates
+	ctx, cancel := context.WithTimeout(context.Background(), QUERY_TIMEOUT)
+	m, err := provider.Search(ctx, query, limit)
+	cancel()
+	if err != nil {
+		Log.Verbose.Printf("[MusicBot] Failed to search music: %s", err)
+		util.EditResponse(s, i, queryErrorMessage(err))
+		return
+	}
+
+	// Keep the candidates until the user selects one of them
+	util.WithLock(&searchResultsMu, func() {
+		searchResults[i.ID] = m
+	})
+	time.AfterFunc(SEARCH_EXPIRE, func() {
+		util.WithLock(&searchResultsMu, func() {
+			delete(searchResults, i.ID)
+		})
+	})
+
+	// Build the candidates as embeds and the options of the select menu
+	embeds := []*discordgo.MessageEmbed{}
+	selectOptions := []discordgo.SelectMenuOption{}
+	for j, v := range m {
+		embed := &discordgo.MessageEmbed{
+			Title:       util.Truncate(fmt.Sprintf("#%d - %s", j+1, v.Title), 256),
+			URL:         v.WebpageUrl,
+			Description: describeMusic(v),
+			Color:       0x9f7fed,
+		}
+
+		// the empty URL of the thumbnail is rejected by Discord (local, subsonic, etc.)
+		if v.ThumbnailUrl != "" {
+			embed.Thumbnail = &discordgo.MessageEmbedThumbnail{
+				URL: v.ThumbnailUrl,
+			}
+		}
+		embeds = append(embeds, embed)
+
+		selectOptions = append(selectOptions, discordgo.SelectMenuOption{
+			Label:       util.Truncate(fmt.Sprintf("#%d - %s", j+1, v.Title), 100),
+			Value:       strconv.Itoa(j),
+			Description: util.Truncate(describeMusic(v), 100),
+		})
+	}
+
+	util.EditResponseWithComponents(s, i, fmt.Sprintf("**Search results of:** %s\nSelect a music to add to the queue.", query), embeds, []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.SelectMenu{
+					MenuType:    discordgo.StringSelectMenu,
+					CustomID:    "musicbot.search:" + i.ID,
+					Placeholder: "Select a music",
+					Options:     selectOptions,
+				},
+			},
+		},
+	})
+}
+
+// onSearchSelect adds the selected candidate of the search result to the queue. (payload is the interaction ID of the search)
+func onSearchSelect(s *discordgo.Session, i *discordgo.InteractionCreate, payload string) {
+	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
+	})
+
+	var m []Provider.Music
+	util.WithLock(&searchResultsMu, func() {
+		m = searchResults[payload]
+	})
+
+	if len(m) == 0 {
+		util.EditResponseWithComponents(s, i, "**Search result expired!**\nPlease search again.", []*discordgo.MessageEmbed{}, []discordgo.MessageComponent{})
+		return
+	}
+
+	values := i.MessageComponentData().Values
+	if len(values) == 0 {
+		return
+	}
+
+	index, err := strconv.Atoi(values[0])
+	if err != nil || index < 0 || index >= len(m) {
+		util.EditResponse(s, i, "**Invalid selection!**\nPlease search again.")
+		return
+	}
+
+	Log.Verbose.Printf("[MusicBot] Search result selected by %s (C:%s, %s)", i.Member.User.Username, i.ChannelID, m[index].Title)
+	util.EditResponse(s, i, "**Adding song to queue...**\n(The song will automatically play when it's ready.)")
+
+	channelID := getChannelIdByUser(s, i.GuildID, i.Member.User.ID)
+	if channelID == "" {
+		util.EditResponse(s, i, "**Failed to join voice channel.**\nPlease rejoin the voice channel and try again. (or you're not in a voice channel)")
+		return
+	}
+
+	enqueueMusic(s, i, channelID, []Provider.Music{m[index]}, nil)
+}
+
+// describeMusic returns the short description of the music. (e.g. "Uploader · 3:05")
+func describeMusic(m Provider.Music) string {
+	duration := "LIVE"
+	if !m.IsLive {
+		duration = util.FormatDuration(m.Duration)
+	}
+
+	parts := []string{}
+	if m.Uploader != "" {
+		parts = append(parts, m.Uploader)
+	}
+	if m.Duration != 0 || m.IsLive || m.Uploader == "" { // hide the unknown duration if there is anything else
+		parts = append(parts, duration)
+	}
+	if !m.Published.IsZero() {
+		parts = append(parts, m.Published.Format("2006-01-02"))
+	}
+
+	return strings.Join(parts, " · ")
+}
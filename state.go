@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"time"
@@ -11,31 +12,142 @@ import (
 type ChannelID string
 
 // The state of the music for each channel
-var states map[ChannelID]*State = make(map[ChannelID]*State)
+var (
+	statesMu sync.Mutex
+	states   map[ChannelID]*State = make(map[ChannelID]*State)
+)
+
+// The default search provider for each guild
+var (
+	guildProvidersMu sync.RWMutex
+	guildProviders   map[string]string = make(map[string]string)
+)
+
+// The search provider used when the guild has not set its own default.
+const DEFAULT_PROVIDER = "youtube"
 
 var (
 	errEmptyQueue            = errors.New("queue is empty")
 	errIndexOutOfRange       = errors.New("index is out of range")
 	errIndexCannotBeNegative = errors.New("index cannot be negative")
 	errSignalTimeout         = errors.New("signal timeout")
+	errNotReady              = errors.New("music is not ready to play")
 )
 
 func GetState(channelID ChannelID) *State {
+	statesMu.Lock()
+	defer statesMu.Unlock()
+
 	if _, exists := states[channelID]; exists {
 		return states[channelID]
 	}
 
 	states[channelID] = &State{
-		queue:     []Provider.Music{},
-		isPlaying: false,
-		loop:      false,
-		pause:     make(chan bool),
-		skip:      make(chan bool),
+		queue:      []Provider.Music{},
+		isPlaying:  false,
+		loop:       false,
+		pause:      make(chan bool),
+		skip:       make(chan bool),
+		seek:       make(chan SeekFunc),
+		prefetcher: newPrefetcher(),
+		updated:    make(chan struct{}),
 	}
 
 	return states[channelID]
 }
 
+// UpdateDetails fills in the details of the music in the queues of all channels. (chapters, duration and live status)
+//
+// The music from a flat playlist lacks the details until it is resolved right before the download.
+func UpdateDetails(music Provider.Music) {
+	statesMu.Lock()
+	targets := []*State{}
+	for _, state := range states {
+		targets = append(targets, state)
+	}
+	statesMu.Unlock()
+
+	for _, state := range targets {
+		state.updateDetails(music)
+	}
+}
+
+func (s *State) updateDetails(music Provider.Music) {
+	s.Lock()
+	defer s.Unlock()
+
+	for i, m := range s.queue {
+		if m.Id != music.Id {
+			continue
+		}
+
+		s.queue[i].IsLive = music.IsLive
+		if music.Duration != 0 {
+			s.queue[i].Duration = music.Duration
+		}
+		if len(m.Chapters) == 0 {
+			s.queue[i].Chapters = music.Chapters
+		}
+	}
+}
+
+// GetDefaultProvider returns the name of the default search provider of the guild.
+func GetDefaultProvider(guildID string) string {
+	guildProvidersMu.RLock()
+	defer guildProvidersMu.RUnlock()
+
+	if name, exists := guildProviders[guildID]; exists {
+		return name
+	}
+
+	return DEFAULT_PROVIDER
+}
+
+// SetDefaultProvider sets the default search provider of the guild.
+func SetDefaultProvider(guildID, name string) {
+	guildProvidersMu.Lock()
+	defer guildProvidersMu.Unlock()
+
+	guildProviders[guildID] = name
+}
+
+// The maximum number of music to remember in the play history of a guild.
+const HISTORY_SIZE = 50
+
+// The recently played music for each guild (newest first)
+var (
+	historiesMu sync.RWMutex
+	histories   map[string][]Provider.Music = make(map[string][]Provider.Music)
+)
+
+// AddHistory adds the music to the front of the play history of the guild.
+// if the music is already in the history, it is moved to the front.
+func AddHistory(guildID string, music Provider.Music) {
+	historiesMu.Lock()
+	defer historiesMu.Unlock()
+
+	history := []Provider.Music{music}
+	for _, m := range histories[guildID] {
+		if m.Id != music.Id {
+			history = append(history, m)
+		}
+	}
+
+	if len(history) > HISTORY_SIZE {
+		history = history[:HISTORY_SIZE]
+	}
+
+	histories[guildID] = history
+}
+
+// GetHistory returns the play history of the guild. (newest first)
+func GetHistory(guildID string) []Provider.Music {
+	historiesMu.RLock()
+	defer historiesMu.RUnlock()
+
+	return histories[guildID]
+}
+
 // States are created per channel and are a kind of multifunctional queue, with a focus on music queues.
 // It can also be locked if necessary with an RWMutex.
 type State struct {
@@ -45,6 +157,25 @@ type State struct {
 	loop      bool
 	pause     chan bool
 	skip      chan bool
+	seek      chan SeekFunc
+
+	prefetcher  *prefetcher        // keeps the front of the queue downloaded (see Prefetch)
+	cancelFront context.CancelFunc // cancels the wait for the download of the front (nil if not waiting)
+	updated     chan struct{}      // closed and replaced whenever the queue is changed (see Updated)
+}
+
+// Updated returns the channel that is closed when the queue is changed next time.
+func (s *State) Updated() <-chan struct{} {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.updated
+}
+
+// notifyUpdate wakes up the waiters of the change of the queue. (s must be locked)
+func (s *State) notifyUpdate() {
+	close(s.updated)
+	s.updated = make(chan struct{})
 }
 
 func (s *State) GetQueue() []Provider.Music {
@@ -67,6 +198,8 @@ func (s *State) Enqueue(music ...Provider.Music) error {
 	defer s.Unlock()
 
 	s.queue = append(s.queue, music...)
+	musicCache.acquire(music...)
+	s.notifyUpdate()
 	return nil
 }
 
@@ -78,7 +211,9 @@ func (s *State) Dequeue() error {
 		return errEmptyQueue
 	}
 
+	musicCache.release(s.queue[0])
 	s.queue = s.queue[1:]
+	s.notifyUpdate()
 	return nil
 }
 
@@ -98,9 +233,16 @@ func (s *State) Remove(index int) (Provider.Music, error) {
 		return Provider.Music{}, errIndexOutOfRange
 	}
 
+	// the player stops waiting for the removed front
+	if index == 0 && s.cancelFront != nil {
+		s.cancelFront()
+	}
+
 	// Remove the music at the specified index
 	target := s.queue[index]
 	s.queue = append(s.queue[:index], s.queue[index+1:]...)
+	musicCache.release(target)
+	s.notifyUpdate()
 
 	return target, nil
 }
@@ -119,6 +261,8 @@ func (s *State) Insert(index int, music ...Provider.Music) error {
 
 	// Insert the music at the specified index
 	s.queue = append(s.queue[:index], append(music, s.queue[index:]...)...)
+	musicCache.acquire(music...)
+	s.notifyUpdate()
 	return nil
 }
 
@@ -166,6 +310,8 @@ func (s *State) Pop() Provider.Music {
 	// Remove the first element from the queue
 	target := s.queue[0]
 	s.queue = s.queue[1:]
+	musicCache.release(target)
+	s.notifyUpdate()
 
 	return target
 }
@@ -189,6 +335,43 @@ func (s *State) IsExistMusic(music Provider.Music) bool {
 	return false
 }
 
+// RemoveWhere removes the music that fn reports true from the queue, and returns the number of the removed music.
+// (the playing music at the front is not removed)
+func (s *State) RemoveWhere(fn func(music Provider.Music) bool) int {
+	s.Lock()
+	defer s.Unlock()
+
+	queue := []Provider.Music{}
+	removed := []Provider.Music{}
+	for i, m := range s.queue {
+		if (i > 0 || !s.isPlaying) && fn(m) {
+			removed = append(removed, m)
+			continue
+		}
+		queue = append(queue, m)
+	}
+
+	s.queue = queue
+	musicCache.release(removed...)
+	s.notifyUpdate()
+	return len(removed)
+}
+
+// PositionOf returns the index of the entry in the queue. (-1 if not exists)
+// the entries of the same music are told apart by their tags. (see Provider.Music.Tag)
+func (s *State) PositionOf(entry Provider.Music) int {
+	s.RLock()
+	defer s.RUnlock()
+
+	for i, m := range s.queue {
+		if m.Id == entry.Id && m.Tag == entry.Tag {
+			return i
+		}
+	}
+
+	return -1
+}
+
 // Pause the music (send a pause signal to the music player thread)
 func (s *State) Pause() error {
 	s.Lock()
@@ -198,6 +381,11 @@ func (s *State) Pause() error {
 		return errEmptyQueue
 	}
 
+	// the player is waiting for the download of the front, so there is nothing to pause yet
+	if s.cancelFront != nil {
+		return errNotReady
+	}
+
 	// Music player control thread
 	done := make(chan bool)
 	go func() {
@@ -228,6 +416,12 @@ func (s *State) Skip() error {
 		return errEmptyQueue
 	}
 
+	// the player is waiting for the download of the front, so it is not playing yet
+	if s.cancelFront != nil {
+		s.cancelFront()
+		return nil
+	}
+
 	// Music player control thread
 	done := make(chan bool)
 	go func() {
@@ -248,3 +442,33 @@ func (s *State) Skip() error {
 		return nil
 	}
 }
+
+// Seek the music (send a seek signal to the music player thread)
+func (s *State) Seek(fn SeekFunc) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.queue) == 0 {
+		return errEmptyQueue
+	}
+
+	// Music player control thread
+	done := make(chan bool)
+	go func() {
+		// Send seek signal to the music player thread
+		s.seek <- fn
+
+		// Wait for the music player control finishes
+		done <- true
+	}()
+
+	select {
+	case <-time.After(3 * time.Second):
+		// if the music player control thread doesn't finish in 3 seconds
+		return errSignalTimeout
+
+	case <-done:
+		// if the music player control thread finishes (successfully seeked)
+		return nil
+	}
+}
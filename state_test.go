@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	Provider "github.com/thirdscam/chatanium-musicbot/provider"
+)
+
+// newTestState returns the state of the channel named after the test. (removed when the test ends)
+func newTestState(t *testing.T) *State {
+	channelID := ChannelID(t.Name())
+	t.Cleanup(func() {
+		statesMu.Lock()
+		delete(states, channelID)
+		statesMu.Unlock()
+	})
+
+	return GetState(channelID)
+}
+
+// newWaitingState returns the state whose player waits for the download of the front, which never finishes.
+func newWaitingState(t *testing.T) (*State, chan error) {
+	music := Provider.Music{Id: Provider.MusicID("TEST:" + t.Name()), Title: t.Name()}
+
+	state := newTestState(t)
+	state.Enqueue(music)
+	state.prefetcher.tasks[music.Id] = &prefetchTask{cancel: func() {}, done: make(chan struct{})}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- state.waitFront(music)
+	}()
+
+	// wait until the player starts waiting
+	for deadline := time.Now().Add(time.Second); ; {
+		state.RLock()
+		isWaiting := state.cancelFront != nil
+		state.RUnlock()
+
+		if isWaiting {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the player does not wait for the download")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	return state, result
+}
+
+func TestWaitFrontPause(t *testing.T) {
+	state, result := newWaitingState(t)
+
+	if err := state.Pause(); !errors.Is(err, errNotReady) {
+		t.Fatalf("got %v, want errNotReady", err)
+	}
+
+	// the pause does not stop the wait
+	select {
+	case err := <-result:
+		t.Fatalf("the wait is stopped by the pause: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	state.Skip()
+	<-result
+}
+
+func TestWaitFrontSkip(t *testing.T) {
+	state, result := newWaitingState(t)
+
+	if err := state.Skip(); err != nil {
+		t.Fatalf("skip while downloading: %v", err)
+	}
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("got %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the wait is not stopped by the skip")
+	}
+
+	// the skip signal is not left for the next music
+	select {
+	case <-state.skip:
+		t.Fatal("the skip signal is sent to the player")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWaitForFront(t *testing.T) {
+	state := newTestState(t)
+
+	// the entry of the job is behind the prefetch window, and the same music is queued by another user
+	music := Provider.Music{Id: "TEST:music"}
+	for range PREFETCH_AHEAD + 1 {
+		state.Enqueue(Provider.Music{Id: "TEST:other"})
+	}
+	entry := music
+	entry.Tag = "import:1:0"
+	state.Enqueue(music, entry)
+
+	result := make(chan bool, 1)
+	go func() {
+		result <- waitForFront(context.Background(), state, entry)
+	}()
+
+	select {
+	case <-result:
+		t.Fatal("the entry is not in the prefetch window yet")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the entry is moved into the window by the change of the queue (behind the other entry of the music)
+	state.Dequeue()
+	state.Dequeue()
+	select {
+	case ok := <-result:
+		if !ok {
+			t.Fatal("the entry is reported as removed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the change of the queue does not wake up the wait")
+	}
+
+	// the removal of the other entry of the same music does not remove the entry
+	state.RemoveWhere(func(m Provider.Music) bool { return m.Id == music.Id && m.Tag == "" })
+	if state.PositionOf(entry) < 0 {
+		t.Fatal("the entry of the job is removed with the other entry")
+	}
+
+	state.RemoveWhere(func(m Provider.Music) bool { return m.Tag == entry.Tag })
+	if waitForFront(context.Background(), state, entry) {
+		t.Fatal("the removed entry is reported as queued")
+	}
+}
@@ -1,6 +1,7 @@
 package util
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
@@ -8,7 +9,9 @@ import (
 	Url "net/url"
 	"os"
 	"path"
+	"regexp"
 	"sync"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/thirdscam/chatanium/src/Util/Log"
@@ -39,9 +42,34 @@ func GetSha256Hash(s string) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
+// IsUrl reports whether the string is a http(s) URL with a host. (a bare path such as "/etc/passwd" is not)
 func IsUrl(url string) bool {
-	_, err := Url.ParseRequestURI(url)
-	return err == nil
+	u, err := Url.ParseRequestURI(url)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// RedactUrl hides the query and the user info of the URL, which can have the credentials.
+// (e.g. api_key of Jellyfin, or the token and salt of Subsonic)
+func RedactUrl(url string) string {
+	u, err := Url.Parse(url)
+	if err != nil || (u.RawQuery == "" && u.User == nil) {
+		return url
+	}
+
+	u.User = nil
+	if u.RawQuery != "" {
+		u.RawQuery = "REDACTED"
+	}
+
+	return u.String()
+}
+
+// e.g. the URL in the messages of ffmpeg
+var urlInText = regexp.MustCompile(`https?://[^\s"'<>]+`)
+
+// RedactText hides the credentials of the URLs in the text. (see RedactUrl)
+func RedactText(text string) string {
+	return urlInText.ReplaceAllStringFunc(text, RedactUrl)
 }
 
 func IsYoutubeUrl(url string) bool {
@@ -50,7 +78,12 @@ func IsYoutubeUrl(url string) bool {
 		return false
 	}
 
-	return u.Host == "www.youtube.com" || u.Host == "youtube.com" || u.Host == "youtu.be"
+	switch u.Host {
+	case "www.youtube.com", "youtube.com", "m.youtube.com", "music.youtube.com", "youtu.be":
+		return true
+	}
+
+	return false
 }
 
 func IsYoutubePlaylist(url string) bool {
@@ -62,6 +95,16 @@ func IsYoutubePlaylist(url string) bool {
 	return u.Host == "www.youtube.com" && u.Path == "/playlist"
 }
 
+// GetOptions returns the options of the command as a map of option name.
+func GetOptions(i *discordgo.InteractionCreate) map[string]*discordgo.ApplicationCommandInteractionDataOption {
+	result := map[string]*discordgo.ApplicationCommandInteractionDataOption{}
+	for _, v := range i.ApplicationCommandData().Options {
+		result[v.Name] = v
+	}
+
+	return result
+}
+
 func EphemeralResponse(s *discordgo.Session, i *discordgo.InteractionCreate, content string) {
 	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
@@ -78,6 +121,51 @@ func EditResponse(s *discordgo.Session, i *discordgo.InteractionCreate, content
 	})
 }
 
+// EditResponseWithComponents edits the response with embeds and message components. (select menus, buttons, etc.)
+func EditResponseWithComponents(s *discordgo.Session, i *discordgo.InteractionCreate, content string, embeds []*discordgo.MessageEmbed, components []discordgo.MessageComponent) {
+	s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+		Content:    &content,
+		Embeds:     &embeds,
+		Components: &components,
+	})
+}
+
+// FormatDuration formats the duration as a clock. (e.g. 3:05, 1:02:03)
+func FormatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	h := int(d / time.Hour)
+	m := int(d % time.Hour / time.Minute)
+	sec := int(d % time.Minute / time.Second)
+
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, sec)
+	}
+	return fmt.Sprintf("%d:%02d", m, sec)
+}
+
+// Truncate cuts the string to the maximum length (in runes) with an ellipsis.
+func Truncate(s string, max int) string {
+	r := []rune(s)
+	if len(r) <= max {
+		return s
+	}
+
+	return string(r[:max-1]) + "…"
+}
+
+// SleepContext waits for the duration, and returns false if ctx is done before that.
+func SleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
 func WithLock(mu *sync.Mutex, fn func()) {
 	mu.Lock()
 	defer mu.Unlock()